@@ -0,0 +1,120 @@
+package app
+
+import (
+	"fmt"
+
+	"github.com/spf13/cobra"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/serializer"
+	utilerrors "k8s.io/apimachinery/pkg/util/errors"
+	genericapiserver "k8s.io/apiserver/pkg/server"
+	cliflag "k8s.io/component-base/cli/flag"
+	"k8s.io/component-base/term"
+	"k8s.io/kube-openapi/pkg/common"
+
+	"github.com/everoute/runtime/pkg/controller"
+	"github.com/everoute/runtime/pkg/options"
+	"github.com/everoute/runtime/pkg/server"
+	"github.com/everoute/runtime/pkg/version"
+)
+
+// APIGroupInstaller installs api groups into the apiserver, the config has been applied
+type APIGroupInstaller func(apiserver *genericapiserver.GenericAPIServer, config *options.RecommendedConfig) error
+
+// ControllerRegistrar registers controllers into the manager, the clientset and informers
+// of the config are available, but the apiserver has not been created
+type ControllerRegistrar func(manager *controller.Manager, config *options.RecommendedConfig) error
+
+// CommandConfig describes the apiserver served by the command
+type CommandConfig struct {
+	// Name is the command name, also used as the apiserver name
+	Name  string
+	Short string
+
+	Codecs serializer.CodecFactory
+	// Scheme enables the openapi with the OpenAPIDefinitions, types registered
+	// in the scheme fallback to objects if missing in the definitions
+	Scheme             *runtime.Scheme
+	OpenAPIDefinitions common.GetOpenAPIDefinitions
+	// EtcdPrefix and StorageCodec decide how objects are stored in etcd
+	EtcdPrefix   string
+	StorageCodec runtime.Codec
+
+	APIGroups   []APIGroupInstaller
+	Controllers []ControllerRegistrar
+	// AdmissionPlugins are in-process admission plugins run before the webhooks, the
+	// admission chain is configured by the admission flags
+	AdmissionPlugins []options.AdmissionPlugin
+	// Options are extra options applied before the leader election
+	Options []options.Options
+}
+
+// NewCommand returns a command runs the apiserver until SIGTERM or SIGINT received, or
+// another node becomes leader. The returned error could be used as the exit code by server.Exit.
+func NewCommand(c CommandConfig) *cobra.Command {
+	extraOptions := []options.Options{
+		options.NewNamedOptions[*options.RecommendedConfig]("admission", options.NewAdmissionOptions(c.AdmissionPlugins...)),
+		options.NewNamedOptions[*options.RecommendedConfig]("controller", newControllerOptions(c.Controllers)),
+	}
+	if c.Scheme != nil {
+		extraOptions = append(extraOptions, options.NewOpenAPIOptions(c.Name, c.OpenAPIDefinitions, c.Scheme))
+	}
+	opts := options.NewRecommendedOptions(c.EtcdPrefix, c.StorageCodec, append(extraOptions, c.Options...)...)
+
+	cmd := &cobra.Command{
+		Use:          c.Name,
+		Short:        c.Short,
+		SilenceUsage: true,
+		RunE: func(cmd *cobra.Command, _ []string) error {
+			if errs := opts.Validate(); len(errs) != 0 {
+				return utilerrors.NewAggregate(errs)
+			}
+			return run(cmd, c, opts)
+		},
+	}
+
+	fss := cliflag.NamedFlagSets{}
+	options.AddNamedFlags[*options.RecommendedConfig](opts, &fss, "misc")
+	for _, fs := range fss.FlagSets {
+		cmd.Flags().AddFlagSet(fs)
+	}
+	cols, _, _ := term.TerminalSize(cmd.OutOrStdout())
+	cliflag.SetUsageAndHelpFunc(cmd, fss, cols)
+
+	cmd.AddCommand(newVersionCommand())
+	return cmd
+}
+
+func run(cmd *cobra.Command, c CommandConfig, opts options.Options) error {
+	config := options.NewRecommendedConfig(c.Codecs)
+	config.Version = version.GetVersionInfo()
+	if err := opts.ApplyTo(config); err != nil {
+		return err
+	}
+
+	delegate, err := config.NewDelegationTarget()
+	if err != nil {
+		return err
+	}
+	apiserver, err := config.Complete().New(c.Name, delegate)
+	if err != nil {
+		return err
+	}
+	for _, install := range c.APIGroups {
+		if err := install(apiserver, config); err != nil {
+			return fmt.Errorf("install api group: %w", err)
+		}
+	}
+	return server.Run(cmd.Context(), config, apiserver.PrepareRun())
+}
+
+func newVersionCommand() *cobra.Command {
+	return &cobra.Command{
+		Use:   "version",
+		Short: "Print the version information",
+		Args:  cobra.NoArgs,
+		Run: func(cmd *cobra.Command, _ []string) {
+			fmt.Fprintln(cmd.OutOrStdout(), version.GetHumanVersion())
+		},
+	}
+}
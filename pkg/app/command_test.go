@@ -0,0 +1,96 @@
+package app_test
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"testing"
+
+	. "github.com/onsi/gomega"
+	"go.uber.org/atomic"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apiserver/pkg/admission"
+	genericapiserver "k8s.io/apiserver/pkg/server"
+	"k8s.io/client-go/discovery"
+	"k8s.io/client-go/kubernetes/scheme"
+
+	"github.com/everoute/runtime/pkg/app"
+	"github.com/everoute/runtime/pkg/controller"
+	"github.com/everoute/runtime/pkg/options"
+	. "github.com/everoute/runtime/pkg/util/testing"
+	"github.com/everoute/runtime/pkg/version"
+)
+
+func newCommandConfig(installed, started *atomic.Bool) app.CommandConfig {
+	return app.CommandConfig{
+		Name:         "runtime-apiserver-unittest",
+		Codecs:       scheme.Codecs,
+		EtcdPrefix:   "/everoute/unittest",
+		StorageCodec: scheme.Codecs.LegacyCodec(metav1.SchemeGroupVersion),
+		APIGroups: []app.APIGroupInstaller{
+			func(*genericapiserver.GenericAPIServer, *options.RecommendedConfig) error {
+				installed.Store(true)
+				return nil
+			},
+		},
+		AdmissionPlugins: []options.AdmissionPlugin{{
+			Name: "Unittest",
+			Factory: func(io.Reader) (admission.Interface, error) {
+				return admission.NewHandler(admission.Create), nil
+			},
+		}},
+		Controllers: []app.ControllerRegistrar{
+			func(manager *controller.Manager, config *options.RecommendedConfig) error {
+				Expect(config.Clientset).ShouldNot(BeNil())
+				manager.Register("unittest", func(ctx context.Context) { started.Store(true); <-ctx.Done() }, false)
+				return nil
+			},
+		},
+	}
+}
+
+func TestNewCommand(t *testing.T) {
+	RegisterTestingT(t)
+
+	var installed, started atomic.Bool
+	cmd := app.NewCommand(newCommandConfig(&installed, &started))
+	config := NewTestingClient(t, cmd)
+
+	Expect(installed.Load()).Should(BeTrue())
+	Eventually(started.Load).Should(BeTrue())
+
+	serverVersion, err := discovery.NewDiscoveryClientForConfigOrDie(config).ServerVersion()
+	Expect(err).ShouldNot(HaveOccurred())
+	Expect(serverVersion.GitVersion).Should(Equal(version.GetVersionInfo().GitVersion))
+}
+
+func TestNewCommandHelp(t *testing.T) {
+	RegisterTestingT(t)
+
+	var installed, started atomic.Bool
+	var out bytes.Buffer
+	cmd := app.NewCommand(newCommandConfig(&installed, &started))
+	cmd.SetOut(&out)
+
+	t.Run("should print version", func(t *testing.T) {
+		out.Reset()
+		cmd.SetArgs([]string{"version"})
+		Expect(cmd.Execute()).ShouldNot(HaveOccurred())
+		Expect(out.String()).Should(Equal(version.GetHumanVersion() + "\n"))
+	})
+
+	t.Run("should print grouped flags", func(t *testing.T) {
+		out.Reset()
+		cmd.SetArgs([]string{"--help"})
+		Expect(cmd.Execute()).ShouldNot(HaveOccurred())
+		Expect(out.String()).Should(ContainSubstring("Etcd flags:"))
+		Expect(out.String()).Should(ContainSubstring("Leader election flags:"))
+		Expect(out.String()).Should(ContainSubstring("--election-enabled"))
+		Expect(out.String()).Should(ContainSubstring("Admission flags:"))
+		Expect(out.String()).Should(ContainSubstring("Unittest"))
+		Expect(out.String()).Should(ContainSubstring("Controller flags:"))
+		Expect(out.String()).Should(ContainSubstring("--controller-debug-handlers"))
+	})
+
+	Expect(installed.Load()).Should(BeFalse())
+}
@@ -0,0 +1,37 @@
+package app
+
+import (
+	"fmt"
+
+	"github.com/spf13/pflag"
+	utilerrors "k8s.io/apimachinery/pkg/util/errors"
+
+	"github.com/everoute/runtime/pkg/controller"
+	"github.com/everoute/runtime/pkg/options"
+)
+
+// newControllerOptions registers controllers into the manager with the config, the
+// manager runs the controllers by the lifecycle hooks of the config
+func newControllerOptions(registrars []ControllerRegistrar) options.Options {
+	return &controllerOptions{manager: controller.NewManager(), registrars: registrars}
+}
+
+type controllerOptions struct {
+	manager    *controller.Manager
+	registrars []ControllerRegistrar
+}
+
+func (o *controllerOptions) AddFlags(flagSet *pflag.FlagSet) { o.manager.AddFlags(flagSet) }
+func (o *controllerOptions) Validate() []error               { return nil }
+
+func (o *controllerOptions) ApplyTo(config *options.RecommendedConfig) error {
+	for _, register := range o.registrars {
+		if err := register(o.manager, config); err != nil {
+			return fmt.Errorf("register controller: %w", err)
+		}
+	}
+	if errs := o.manager.Validate(); len(errs) != 0 {
+		return utilerrors.NewAggregate(errs)
+	}
+	return o.manager.ApplyTo(config)
+}
@@ -0,0 +1,246 @@
+package controller
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/spf13/pflag"
+	"go.uber.org/atomic"
+	utilerrors "k8s.io/apimachinery/pkg/util/errors"
+	"k8s.io/apimachinery/pkg/util/sets"
+	"k8s.io/apimachinery/pkg/util/wait"
+	genericapiserver "k8s.io/apiserver/pkg/server"
+	"k8s.io/apiserver/pkg/server/healthz"
+	"k8s.io/client-go/tools/cache"
+	"k8s.io/klog/v2"
+
+	"github.com/everoute/runtime/pkg/lifecycle"
+	"github.com/everoute/runtime/pkg/options"
+)
+
+// RunFunc runs the controller until the context done
+type RunFunc func(ctx context.Context)
+
+// Manager runs registered controllers, the leader-only controllers
+// only run when the current node is leading
+type Manager struct {
+	controllers []*controllerRunner
+
+	// debugHandlers are installed into the apiserver when enableDebugHandlers
+	debugHandlers       []debugHandler
+	enableDebugHandlers bool
+
+	leadingLock   sync.Mutex
+	leadingCancel context.CancelFunc
+	leadingWait   *sync.WaitGroup
+}
+
+// NewManager creates a new instance of controller manager
+func NewManager() *Manager {
+	return &Manager{}
+}
+
+// Register adds controller into the manager, the controller starts after all cacheSyncs synced.
+// It must be called before the manager applied to the RecommendedConfig.
+func (m *Manager) Register(name string, run RunFunc, leaderOnly bool, cacheSyncs ...cache.InformerSynced) {
+	m.controllers = append(m.controllers, &controllerRunner{
+		name:       name,
+		run:        run,
+		leaderOnly: leaderOnly,
+		cacheSyncs: cacheSyncs,
+	})
+}
+
+// RegisterDebugHandler adds the debug handler of a controller, e.g. the status of the reconciled
+// objects. The handler is served at the path behind the authentication and authorization of the
+// apiserver when --controller-debug-handlers enabled. It must be called before the manager applied.
+func (m *Manager) RegisterDebugHandler(path string, handler http.Handler) {
+	m.debugHandlers = append(m.debugHandlers, debugHandler{path: path, handler: handler})
+}
+
+func (m *Manager) AddFlags(flagSet *pflag.FlagSet) {
+	flagSet.BoolVar(&m.enableDebugHandlers, "controller-debug-handlers", m.enableDebugHandlers,
+		"serve the debug handlers registered by the controllers, e.g. the status of the reconciled objects")
+}
+
+func (m *Manager) Validate() []error {
+	var errs []error
+	names := sets.New[string]()
+	for _, c := range m.controllers {
+		if c.name == "" {
+			errs = append(errs, fmt.Errorf("controller name must not be empty"))
+		}
+		if names.Has(c.name) {
+			errs = append(errs, fmt.Errorf("controller %s has been registered", c.name))
+		}
+		if c.run == nil {
+			errs = append(errs, fmt.Errorf("controller %s run func must not be nil", c.name))
+		}
+		names.Insert(c.name)
+	}
+
+	paths := sets.New[string]()
+	for _, h := range m.debugHandlers {
+		if !strings.HasPrefix(h.path, debugPathPrefix) {
+			errs = append(errs, fmt.Errorf("debug handler path %q must start with %s", h.path, debugPathPrefix))
+		}
+		if paths.Has(h.path) {
+			errs = append(errs, fmt.Errorf("debug handler %s has been registered", h.path))
+		}
+		if h.handler == nil {
+			errs = append(errs, fmt.Errorf("debug handler %s must not be nil", h.path))
+		}
+		paths.Insert(h.path)
+	}
+	return errs
+}
+
+// ApplyTo registers the manager into the lifecycle registry of the config. The leader-only
+// controllers run in started-leading phase and stop in stopped-leading phase, others run
+// in post-start phase.
+func (m *Manager) ApplyTo(config *options.RecommendedConfig) error {
+	if config.Lifecycle == nil {
+		return fmt.Errorf("controller manager requires the lifecycle registry")
+	}
+
+	for _, c := range m.controllers {
+		config.AddHealthChecks(healthz.NamedCheck("controller-"+c.name, c.check))
+	}
+
+	if m.enableDebugHandlers && len(m.debugHandlers) != 0 {
+		buildHandlerChainFunc := config.BuildHandlerChainFunc
+		config.BuildHandlerChainFunc = func(apiHandler http.Handler, c *genericapiserver.Config) http.Handler {
+			// serve after the request authenticated and authorized, same as the apis
+			return buildHandlerChainFunc(m.withDebugHandlers(apiHandler), c)
+		}
+	}
+
+	return utilerrors.NewAggregate([]error{
+		config.Lifecycle.Register(lifecycle.Hook{
+			Name:  "controller-manager",
+			Phase: lifecycle.PhasePostStart,
+			Func: func(ctx context.Context) error {
+				for _, c := range m.controllers {
+					if !c.leaderOnly {
+						c.start(ctx, nil)
+					}
+				}
+				return nil
+			},
+		}),
+		config.Lifecycle.Register(lifecycle.Hook{
+			Name:  "controller-manager",
+			Phase: lifecycle.PhaseStartedLeading,
+			Func:  func(ctx context.Context) error { m.startLeading(ctx); return nil },
+		}),
+		config.Lifecycle.Register(lifecycle.Hook{
+			Name:  "controller-manager",
+			Phase: lifecycle.PhaseStoppedLeading,
+			Func:  func(context.Context) error { m.stopLeading(); return nil },
+		}),
+	})
+}
+
+func (m *Manager) withDebugHandlers(handler http.Handler) http.Handler {
+	handlers := make(map[string]http.Handler, len(m.debugHandlers))
+	for _, h := range m.debugHandlers {
+		handlers[h.path] = h.handler
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if debugHandler, ok := handlers[req.URL.Path]; ok {
+			debugHandler.ServeHTTP(w, req)
+			return
+		}
+		handler.ServeHTTP(w, req)
+	})
+}
+
+func (m *Manager) startLeading(ctx context.Context) {
+	m.stopLeading() // make sure controllers from the last leading term stopped
+
+	m.leadingLock.Lock()
+	defer m.leadingLock.Unlock()
+
+	ctx, m.leadingCancel = context.WithCancel(ctx)
+	m.leadingWait = &sync.WaitGroup{}
+	for _, c := range m.controllers {
+		if c.leaderOnly {
+			c.start(ctx, m.leadingWait)
+		}
+	}
+}
+
+func (m *Manager) stopLeading() {
+	m.leadingLock.Lock()
+	defer m.leadingLock.Unlock()
+
+	if m.leadingCancel != nil {
+		m.leadingCancel()
+		m.leadingWait.Wait()
+		m.leadingCancel, m.leadingWait = nil, nil
+	}
+
+	// leader-only controllers are healthy when not leading
+	for _, c := range m.controllers {
+		if c.leaderOnly {
+			c.err.Store(nil)
+		}
+	}
+}
+
+// debugPathPrefix is the required prefix of the debug handler paths
+const debugPathPrefix = "/debug/"
+
+type debugHandler struct {
+	path    string
+	handler http.Handler
+}
+
+type controllerRunner struct {
+	name       string
+	run        RunFunc
+	leaderOnly bool
+	cacheSyncs []cache.InformerSynced
+	err        atomic.Error
+}
+
+func (c *controllerRunner) start(ctx context.Context, wg *sync.WaitGroup) {
+	klog.Infof("starting controller %s", c.name)
+	if wg != nil {
+		wg.Add(1)
+	}
+	go func() {
+		if wg != nil {
+			defer wg.Done()
+		}
+		wait.UntilWithContext(ctx, c.runOnce, time.Second)
+		klog.Infof("controller %s stopped", c.name)
+	}()
+}
+
+func (c *controllerRunner) runOnce(ctx context.Context) {
+	defer func() {
+		if r := recover(); r != nil {
+			klog.Errorf("controller %s panic: %v", c.name, r)
+			c.err.Store(fmt.Errorf("controller %s panic: %v", c.name, r))
+			return
+		}
+		if ctx.Err() == nil {
+			c.err.Store(fmt.Errorf("controller %s exited unexpectedly", c.name))
+		}
+	}()
+
+	if !cache.WaitForNamedCacheSync(c.name, ctx.Done(), c.cacheSyncs...) {
+		return
+	}
+	c.err.Store(nil)
+	c.run(ctx)
+}
+
+func (c *controllerRunner) check(*http.Request) error {
+	return c.err.Load()
+}
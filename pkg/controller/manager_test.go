@@ -0,0 +1,138 @@
+package controller_test
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	. "github.com/onsi/gomega"
+	"github.com/spf13/pflag"
+	"go.uber.org/atomic"
+	"k8s.io/apimachinery/pkg/util/rand"
+	genericapiserver "k8s.io/apiserver/pkg/server"
+	"k8s.io/apiserver/pkg/server/healthz"
+	"k8s.io/client-go/kubernetes/scheme"
+
+	"github.com/everoute/runtime/pkg/controller"
+	"github.com/everoute/runtime/pkg/lifecycle"
+	"github.com/everoute/runtime/pkg/options"
+	. "github.com/everoute/runtime/pkg/util/testing"
+)
+
+func TestManager(t *testing.T) {
+	RegisterTestingT(t)
+
+	var normalRunning, leaderRunning atomic.Bool
+	runFunc := func(running *atomic.Bool) controller.RunFunc {
+		return func(ctx context.Context) {
+			running.Store(true)
+			<-ctx.Done()
+			running.Store(false)
+		}
+	}
+
+	m := controller.NewManager()
+	m.Register("normal", runFunc(&normalRunning), false)
+	m.Register("leader", runFunc(&leaderRunning), true)
+	m.Register("crash", func(ctx context.Context) { panic("unexpected") }, false)
+	Expect(m.Validate()).Should(HaveLen(0))
+
+	// the manager could be applied in any order with the election options
+	config := options.NewRecommendedConfig(scheme.Codecs)
+	config.LeaderElectionClient = NewFakeLeaderElectionClient(rand.String(20))
+	Expect(m.ApplyTo(config)).ShouldNot(HaveOccurred())
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	Expect(config.Lifecycle.Run(ctx, lifecycle.PhasePostStart)).ShouldNot(HaveOccurred())
+
+	t.Run("should run normal controller without leading", func(t *testing.T) {
+		Eventually(normalRunning.Load).Should(BeTrue())
+		Consistently(leaderRunning.Load, 200*time.Millisecond).Should(BeFalse())
+	})
+
+	t.Run("should run leader-only controller when leading", func(t *testing.T) {
+		Expect(config.Lifecycle.Run(ctx, lifecycle.PhaseStartedLeading)).ShouldNot(HaveOccurred())
+		Eventually(leaderRunning.Load).Should(BeTrue())
+	})
+
+	t.Run("should stop leader-only controller when stopped leading", func(t *testing.T) {
+		Expect(config.Lifecycle.Run(ctx, lifecycle.PhaseStoppedLeading)).ShouldNot(HaveOccurred())
+		Expect(leaderRunning.Load()).Should(BeFalse())
+		Expect(normalRunning.Load()).Should(BeTrue())
+	})
+
+	t.Run("should report controller health", func(t *testing.T) {
+		checks := make(map[string]healthz.HealthChecker)
+		for _, check := range config.HealthzChecks {
+			checks[check.Name()] = check
+		}
+		Expect(checks).Should(HaveKey("controller-normal"))
+		Expect(checks).Should(HaveKey("controller-leader"))
+		Expect(checks).Should(HaveKey("controller-crash"))
+		Expect(checks["controller-normal"].Check(nil)).ShouldNot(HaveOccurred())
+		Expect(checks["controller-leader"].Check(nil)).ShouldNot(HaveOccurred())
+		Eventually(func() error { return checks["controller-crash"].Check(nil) }).Should(HaveOccurred())
+	})
+}
+
+func TestManagerValidate(t *testing.T) {
+	RegisterTestingT(t)
+
+	m := controller.NewManager()
+	m.Register("", func(context.Context) {}, false)
+	m.Register("foo", func(context.Context) {}, false)
+	m.Register("foo", nil, true)
+	m.RegisterDebugHandler("/foo", http.NotFoundHandler())
+	m.RegisterDebugHandler("/debug/bar", http.NotFoundHandler())
+	m.RegisterDebugHandler("/debug/bar", nil)
+	Expect(m.Validate()).Should(HaveLen(6))
+}
+
+func TestManagerApplyWithoutLifecycle(t *testing.T) {
+	RegisterTestingT(t)
+
+	config := options.NewRecommendedConfig(scheme.Codecs)
+	config.Lifecycle = nil
+	Expect(controller.NewManager().ApplyTo(config)).Should(MatchError(ContainSubstring("requires the lifecycle registry")))
+}
+
+func TestManagerDebugHandlers(t *testing.T) {
+	RegisterTestingT(t)
+
+	newHandler := func(enabled bool) http.Handler {
+		m := controller.NewManager()
+		m.RegisterDebugHandler("/debug/foo", http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+			_, _ = w.Write([]byte("foo"))
+		}))
+		flagSet := pflag.NewFlagSet("unittest", pflag.ContinueOnError)
+		m.AddFlags(flagSet)
+		Expect(flagSet.Parse([]string{fmt.Sprintf("--controller-debug-handlers=%t", enabled)})).ShouldNot(HaveOccurred())
+		Expect(m.Validate()).Should(HaveLen(0))
+
+		config := options.NewRecommendedConfig(scheme.Codecs)
+		config.BuildHandlerChainFunc = func(apiHandler http.Handler, _ *genericapiserver.Config) http.Handler { return apiHandler }
+		Expect(m.ApplyTo(config)).ShouldNot(HaveOccurred())
+		return config.BuildHandlerChainFunc(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+			_, _ = w.Write([]byte("api"))
+		}), &config.Config)
+	}
+	serve := func(handler http.Handler, path string) string {
+		recorder := httptest.NewRecorder()
+		handler.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, path, nil))
+		return recorder.Body.String()
+	}
+
+	t.Run("should serve debug handlers when enabled", func(t *testing.T) {
+		handler := newHandler(true)
+		Expect(serve(handler, "/debug/foo")).Should(Equal("foo"))
+		Expect(serve(handler, "/apis")).Should(Equal("api"))
+	})
+
+	t.Run("should not serve debug handlers when disabled", func(t *testing.T) {
+		Expect(serve(newHandler(false), "/debug/foo")).Should(Equal("api"))
+	})
+}
@@ -2,20 +2,30 @@ package service
 
 import (
 	"context"
+	"encoding/json"
 	"fmt"
 	"net"
+	"os"
+	"strings"
 	"time"
 
 	"github.com/samber/lo"
+	"golang.org/x/time/rate"
 	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/equality"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
 	"k8s.io/apimachinery/pkg/types"
 	"k8s.io/apimachinery/pkg/util/runtime"
 	"k8s.io/apimachinery/pkg/util/sets"
 	"k8s.io/apimachinery/pkg/util/wait"
 	"k8s.io/client-go/informers"
 	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/kubernetes/scheme"
+	typedcorev1 "k8s.io/client-go/kubernetes/typed/core/v1"
+	corelisters "k8s.io/client-go/listers/core/v1"
 	"k8s.io/client-go/tools/cache"
+	"k8s.io/client-go/tools/record"
 	"k8s.io/client-go/util/workqueue"
 	"k8s.io/klog/v2"
 
@@ -29,13 +39,24 @@ type Controller struct {
 	serviceLister         cache.Indexer
 	serviceInformerSynced cache.InformerSynced
 
+	// resolve the node-annotation targets, nil if the NodeName not configured
+	nodeLister         corelisters.NodeLister
+	nodeInformerSynced cache.InformerSynced
+
 	// handle leading event
 	electionClient options.LeaderElectionClient
 
-	shouldHandleService func(*corev1.Service) bool
-	publicIP            net.IP
-	clientset           kubernetes.Interface
-	reconcileQueue      workqueue.RateLimitingInterface
+	selectService  func(*corev1.Service) bool
+	publicIP       net.IP
+	config         Config
+	clientset      kubernetes.Interface
+	reconcileQueue workqueue.RateLimitingInterface
+	leaderQueue    *leaderQueue
+	resolver       *leaderResolver
+
+	eventBroadcaster record.EventBroadcaster
+	recorder         record.EventRecorder
+	status           *statusStore
 }
 
 const (
@@ -43,6 +64,71 @@ const (
 	matchExternalServiceIndexValue = "true"
 )
 
+const (
+	// AnnotationLeaderExternalName opts in a service to be handled by the controller when the value is "true"
+	AnnotationLeaderExternalName = "everoute.io/leader-external-name"
+	// AnnotationLeaderIdentity and AnnotationLeaderUpdatedAt record the identity of the node
+	// which updated the service target and the update time in RFC3339, for auditing
+	AnnotationLeaderIdentity  = "everoute.io/leader-identity"
+	AnnotationLeaderUpdatedAt = "everoute.io/leader-updated-at"
+
+	// FieldManager is the manager name of the fields updated by the controller
+	FieldManager = "everoute-leader-external-name"
+
+	// EventReasonUpdated and EventReasonUpdateFailed are reasons of the events on the services
+	EventReasonUpdated       = "Updated"
+	EventReasonUpdateFailed  = "UpdateFailed"
+	EventReasonInvalidTarget = "InvalidTarget"
+)
+
+// Config selects the services handled by the controller. A service is handled if it's
+// in the IncludeServices, or in the Namespaces and matched by the LabelSelector or annotated with
+// AnnotationLeaderExternalName.
+type Config struct {
+	// ResyncPeriod reconciles all matched services periodically if not zero, so that the
+	// targets changed by others are repaired, e.g. the EndpointSlice deleted
+	ResyncPeriod time.Duration
+	// Workers is the number of parallel reconcile workers, defaults to 1
+	Workers int
+	// BaseBackoff and MaxBackoff limit the per-service exponential retry backoff, QPS and
+	// Burst limit the overall reconcile rate, zero values default to the workqueue defaults
+	BaseBackoff time.Duration
+	MaxBackoff  time.Duration
+	QPS         float64
+	Burst       int
+
+	// IncludeServices are the namespace/name keys of services, not limited by the Namespaces
+	IncludeServices []string
+	// LabelSelector selects services by labels, nil selects nothing
+	LabelSelector labels.Selector
+	// Namespaces limit the services selected by labels or annotation, empty means all namespaces
+	Namespaces []string
+	// EndpointSliceEnabled also handles the selected ClusterIP services without selector, the
+	// leader endpoint is published by an EndpointSlice with the publicIP and the service ports
+	EndpointSliceEnabled bool
+
+	// ClearOnRelease clears the targets pointing to this node when the lease released and no new
+	// leader elected. The EndpointSlices are emptied, and the ExternalName set to ReleasedExternalName,
+	// ExternalName services are kept unchanged if ReleasedExternalName is empty. Otherwise the targets
+	// are handed over to the new leader.
+	ClearOnRelease       bool
+	ReleasedExternalName string
+	// StalenessGuard validates the targets against the lease holder from any replica, the targets are
+	// fixed to the address resolved from the holder identity, or cleared if ClearOnRelease and the lease
+	// released. It requires the publicIP of each node same as the address in its identity.
+	StalenessGuard bool
+
+	// DryRun resolves the targets as if leading regardless of the election, and never updates
+	// the targets, the changes are logged and reported by the debug handler
+	DryRun bool
+
+	// Hostname is the target of the services with annotation target hostname, defaults to the os hostname
+	Hostname string
+	// NodeName is the name of the Node object, required by the annotation target node-annotation,
+	// the Node is watched by the shared informer factory if set
+	NodeName string
+}
+
 // New creates a new instance of controller
 func New(
 	clientset kubernetes.Interface,
@@ -51,8 +137,23 @@ func New(
 	publicIP net.IP,
 	resyncPeriod time.Duration,
 	includeServices ...string,
+) *Controller {
+	return NewWithConfig(clientset, kubeFactory, electionClient, publicIP, Config{
+		ResyncPeriod:    resyncPeriod,
+		IncludeServices: includeServices,
+	})
+}
+
+// NewWithConfig creates a new instance of controller with the config
+func NewWithConfig(
+	clientset kubernetes.Interface,
+	kubeFactory informers.SharedInformerFactory,
+	electionClient options.LeaderElectionClient,
+	publicIP net.IP,
+	config Config,
 ) *Controller {
 	serviceInformer := kubeFactory.Core().V1().Services().Informer()
+	rateLimiter := newRateLimiter(config.BaseBackoff, config.MaxBackoff, config.QPS, config.Burst)
 
 	c := &Controller{
 		serviceInformer:       serviceInformer,
@@ -60,42 +161,99 @@ func New(
 		serviceInformerSynced: serviceInformer.HasSynced,
 		electionClient:        electionClient,
 		publicIP:              publicIP,
+		config:                config,
 		clientset:             clientset,
-		reconcileQueue:        workqueue.NewRateLimitingQueue(workqueue.DefaultControllerRateLimiter()),
+		reconcileQueue:        workqueue.NewNamedRateLimitingQueue(rateLimiter, "leader_external_service"),
+		eventBroadcaster:      record.NewBroadcaster(),
+		status:                &statusStore{status: make(map[types.NamespacedName]ServiceStatus)},
 	}
+	c.recorder = c.eventBroadcaster.NewRecorder(scheme.Scheme, corev1.EventSource{
+		Component: FieldManager,
+		Host:      electionClient.Identity(),
+	})
 
-	_ = lo.Must(serviceInformer.AddEventHandlerWithResyncPeriod(cache.ResourceEventHandlerFuncs{
-		AddFunc:    c.handleService,
+	_ = lo.Must(serviceInformer.AddEventHandler(cache.ResourceEventHandlerFuncs{
+		AddFunc:    c.addService,
 		UpdateFunc: c.updateService,
-	}, resyncPeriod))
+		DeleteFunc: c.handleService,
+	}))
 
 	lo.Must0(serviceInformer.AddIndexers(cache.Indexers{
 		matchExternalServiceIndex: c.matchExternalServiceIndexFunc,
 	}))
 
-	c.shouldHandleService = c.shouldHandleServiceFunc(includeServices)
+	c.leaderQueue = &leaderQueue{
+		name:           "leader_external_service",
+		workers:        config.Workers,
+		electionClient: electionClient,
+		queue:          c.reconcileQueue,
+		resyncKey:      types.NamespacedName{},
+		reconcile: func(ctx context.Context, key interface{}) error {
+			return c.doReconcile(ctx, key.(types.NamespacedName))
+		},
+	}
+	c.resolver = &leaderResolver{
+		electionClient: electionClient,
+		publicIP:       publicIP,
+		clearOnRelease: config.ClearOnRelease,
+		stalenessGuard: config.StalenessGuard,
+	}
+	if c.config.Hostname == "" {
+		c.config.Hostname, _ = os.Hostname()
+	}
+	if c.config.NodeName != "" {
+		nodeInformer := kubeFactory.Core().V1().Nodes()
+		c.nodeLister = nodeInformer.Lister()
+		c.nodeInformerSynced = nodeInformer.Informer().HasSynced
+		_ = lo.Must(nodeInformer.Informer().AddEventHandler(cache.ResourceEventHandlerFuncs{
+			AddFunc:    c.handleNode,
+			UpdateFunc: c.updateNode,
+		}))
+	}
+	c.selectService = c.selectServiceFunc(c.config)
 	return c
 }
 
+// newRateLimiter returns the rate limiter like the workqueue.DefaultControllerRateLimiter
+// with the backoff and the bucket limit, zero values default to the workqueue defaults
+func newRateLimiter(baseBackoff, maxBackoff time.Duration, qps float64, burst int) workqueue.RateLimiter {
+	baseBackoff = lo.Ternary(baseBackoff > 0, baseBackoff, 5*time.Millisecond)
+	maxBackoff = lo.Ternary(maxBackoff > 0, maxBackoff, 1000*time.Second)
+	qps = lo.Ternary(qps > 0, qps, 10)
+	burst = lo.Ternary(burst > 0, burst, 100)
+
+	return workqueue.NewMaxOfRateLimiter(
+		workqueue.NewItemExponentialFailureRateLimiter(baseBackoff, maxBackoff),
+		&workqueue.BucketRateLimiter{Limiter: rate.NewLimiter(rate.Limit(qps), burst)},
+	)
+}
+
 // Run begins processing items until the stopCh closed
 func (c *Controller) Run(stopCh <-chan struct{}) {
 	defer runtime.HandleCrash()
 	defer c.reconcileQueue.ShutDown()
 
-	if !cache.WaitForNamedCacheSync("ExternalServiceController", stopCh,
-		c.serviceInformerSynced,
-	) {
+	c.eventBroadcaster.StartRecordingToSink(&typedcorev1.EventSinkImpl{Interface: c.clientset.CoreV1().Events("")})
+	defer c.eventBroadcaster.Shutdown()
+
+	cacheSyncs := []cache.InformerSynced{c.serviceInformerSynced}
+	if c.nodeInformerSynced != nil {
+		cacheSyncs = append(cacheSyncs, c.nodeInformerSynced)
+	}
+	if !cache.WaitForNamedCacheSync("ExternalServiceController", stopCh, cacheSyncs...) {
 		return
 	}
 
 	ctx := wait.ContextForChannel(stopCh)
-	go wait.UntilWithContext(ctx, c.reconcileWorker, time.Second)
-	go wait.UntilWithContext(ctx, c.electionNotifier, time.Second)
+	if c.config.ResyncPeriod > 0 {
+		go wait.UntilWithContext(ctx, func(context.Context) { c.reconcileQueue.Add(types.NamespacedName{}) }, c.config.ResyncPeriod)
+	}
+	c.leaderQueue.run(ctx)
+}
 
-	// note: quick sync when service controller started
-	// make sure we reconcile even if the first election event lost
-	c.reconcileQueue.Add(types.NamespacedName{})
-	<-stopCh
+func (c *Controller) addService(obj interface{}) {
+	c.warnInvalidTarget(obj.(*corev1.Service))
+	c.handleService(obj)
 }
 
 func (c *Controller) handleService(obj interface{}) {
@@ -115,18 +273,86 @@ func (c *Controller) updateService(old interface{}, new interface{}) {
 	oldService := old.(*corev1.Service)
 	newService := new.(*corev1.Service)
 
-	// handle service when service external-name update
-	if newService.Spec.ExternalName != oldService.Spec.ExternalName && c.shouldHandleService(newService) {
-		c.handleService(newService)
+	// handle service when matching changed, e.g. the selected labels or annotation added, or any
+	// change of the matched service, e.g. the external-name, ports or target annotation. The informer
+	// resync events without changes are ignored, all matched services are requeued every ResyncPeriod.
+	if equality.Semantic.DeepEqual(oldService, newService) {
+		return
+	}
+	c.warnInvalidTarget(newService)
+	if c.shouldHandleService(oldService) || c.shouldHandleService(newService) {
+		c.reconcileQueue.Add(types.NamespacedName{
+			Namespace: newService.Namespace,
+			Name:      newService.Name,
+		})
+	}
+}
+
+// handleNode enqueues the matched services with the target resolved from the annotations of this node
+func (c *Controller) handleNode(obj interface{}) {
+	if obj.(*corev1.Node).Name != c.config.NodeName {
+		return
+	}
+	services, err := c.fetchExternalServices()
+	if err != nil {
+		klog.Errorf("fetch external services: %s", err)
+		return
+	}
+	for _, service := range services {
+		if strings.HasPrefix(service.Annotations[AnnotationLeaderTarget], TargetNodeAnnotationPrefix) {
+			c.reconcileQueue.Add(types.NamespacedName{
+				Namespace: service.Namespace,
+				Name:      service.Name,
+			})
+		}
+	}
+}
+
+func (c *Controller) updateNode(old interface{}, new interface{}) {
+	// only the annotations of the node are resolved as the targets
+	if !equality.Semantic.DeepEqual(old.(*corev1.Node).Annotations, new.(*corev1.Node).Annotations) {
+		c.handleNode(new)
 	}
 }
 
-func (c *Controller) shouldHandleServiceFunc(services []string) func(*corev1.Service) bool {
-	includeServiceSet := sets.New(services...)
+// shouldHandleService returns whether the service is selected and with a valid target
+func (c *Controller) shouldHandleService(service *corev1.Service) bool {
+	return c.selectService(service) && c.validateTarget(service) == nil
+}
+
+// selectServiceFunc returns whether the service is selected by the config regardless of the target
+func (c *Controller) selectServiceFunc(config Config) func(*corev1.Service) bool {
+	includeServiceSet := sets.New(config.IncludeServices...)
+	namespaceSet := sets.New(config.Namespaces...)
 
 	return func(service *corev1.Service) bool {
+		switch {
+		case service.Spec.Type == corev1.ServiceTypeExternalName:
+		case config.EndpointSliceEnabled && isSelectorlessClusterIP(service):
+		default:
+			return false
+		}
 		namespacedName := types.NamespacedName{Namespace: service.Namespace, Name: service.Name}.String()
-		return service.Spec.Type == corev1.ServiceTypeExternalName && includeServiceSet.Has(namespacedName)
+		if includeServiceSet.Has(namespacedName) {
+			return true
+		}
+		if namespaceSet.Len() != 0 && !namespaceSet.Has(service.Namespace) {
+			return false
+		}
+		return service.Annotations[AnnotationLeaderExternalName] == "true" ||
+			(config.LabelSelector != nil && config.LabelSelector.Matches(labels.Set(service.Labels)))
+	}
+}
+
+// warnInvalidTarget records a warning event on the selected service with invalid target, the
+// service is ignored until the target fixed
+func (c *Controller) warnInvalidTarget(service *corev1.Service) {
+	if !c.selectService(service) {
+		return
+	}
+	if err := c.validateTarget(service); err != nil {
+		klog.V(2).Infof("ignore service %s/%s with invalid target: %s", service.Namespace, service.Name, err)
+		c.recorder.Eventf(service, corev1.EventTypeWarning, EventReasonInvalidTarget, "Ignored with invalid target: %s", err)
 	}
 }
 
@@ -137,70 +363,113 @@ func (c *Controller) matchExternalServiceIndexFunc(obj interface{}) ([]string, e
 	return nil, nil
 }
 
-func (c *Controller) reconcileWorker(ctx context.Context) {
-	for {
-		key, quit := c.reconcileQueue.Get()
-		if quit {
-			return
-		}
+func (c *Controller) doReconcile(ctx context.Context, namespacedName types.NamespacedName) error {
+	// followers never update the targets, but still observe them for the status
+	active := c.resolver.active() || c.config.DryRun
 
-		err := c.doReconcile(ctx, key.(types.NamespacedName))
+	if namespacedName == (types.NamespacedName{}) {
+		services, err := c.fetchExternalServices()
 		if err != nil {
-			klog.Errorf("reconcile external service %v: %s", key.(types.NamespacedName).String(), err)
-			c.reconcileQueue.AddRateLimited(key)
-			c.reconcileQueue.Done(key)
-			continue
+			return fmt.Errorf("fetch external services: %w", err)
+		}
+		// resync all services by their own keys, so that reconciled by the parallel workers
+		for _, service := range services {
+			c.reconcileQueue.Add(types.NamespacedName{Namespace: service.Namespace, Name: service.Name})
 		}
+		return nil
+	}
 
-		// stop the rate limiter from tracking the key
-		c.reconcileQueue.Done(key)
-		c.reconcileQueue.Forget(key)
+	obj, exists, err := c.serviceLister.GetByKey(namespacedName.String())
+	if err != nil {
+		return fmt.Errorf("get service %s: %w", namespacedName, err)
 	}
+	if !exists || !c.shouldHandleService(obj.(*corev1.Service)) {
+		// the service has been deleted or no longer matched
+		c.status.delete(namespacedName)
+		return c.cleanupEndpointSlice(ctx, namespacedName)
+	}
+
+	service := obj.(*corev1.Service)
+	err = publishSink(ctx, c.sinkOf(service), c.config.DryRun, func(current string) (string, bool, error) {
+		target, ok, err := c.desiredTargetOf(service, current)
+		if err == nil {
+			c.status.setTarget(service, current, lo.Ternary(ok, target, ""), c.config.DryRun)
+		}
+		return target, ok && active, err
+	})
+	c.status.setResult(namespacedName, err)
+	return err
 }
 
-func (c *Controller) electionNotifier(ctx context.Context) {
-	for c.electionClient.UntilLeadingStateUpdate(ctx.Done()) {
-		c.reconcileQueue.Add(types.NamespacedName{})
+// sinkOf returns the sink which the target of the service published to
+func (c *Controller) sinkOf(service *corev1.Service) Sink {
+	if service.Spec.Type == corev1.ServiceTypeExternalName {
+		return &externalNameSink{controller: c, service: service}
 	}
+	return &endpointSliceSink{controller: c, service: service}
 }
 
-func (c *Controller) doReconcile(ctx context.Context, namespacedName types.NamespacedName) error {
-	if !c.electionClient.IsLeader() { // never update when no-longer leading
+// externalNameSink publishes the target into the ExternalName of the service, the
+// ReleasedExternalName is published instead when the target cleared
+type externalNameSink struct {
+	controller *Controller
+	service    *corev1.Service
+}
+
+func (s *externalNameSink) Name() string {
+	return fmt.Sprintf("service/%s/%s", s.service.Namespace, s.service.Name)
+}
+
+func (s *externalNameSink) Current(context.Context) (string, error) {
+	return s.service.Spec.ExternalName, nil
+}
+
+// changed returns false if the target cleared without the ReleasedExternalName, the ExternalName kept unchanged
+func (s *externalNameSink) changed(current, target string) bool {
+	externalName := s.externalNameOf(target)
+	return externalName != "" && externalName != current
+}
+
+func (s *externalNameSink) externalNameOf(target string) string {
+	return lo.Ternary(target != "", target, s.controller.config.ReleasedExternalName)
+}
+
+func (s *externalNameSink) Publish(ctx context.Context, target string) error {
+	service := s.service
+	externalName := s.externalNameOf(target)
+	if externalName == "" {
 		return nil
 	}
 
-	services, err := c.fetchExternalServices(namespacedName)
+	patch, err := json.Marshal(map[string]interface{}{
+		"metadata": map[string]interface{}{
+			"annotations": map[string]string{
+				AnnotationLeaderIdentity:  s.controller.electionClient.Identity(),
+				AnnotationLeaderUpdatedAt: time.Now().UTC().Format(time.RFC3339),
+			},
+		},
+		"spec": map[string]interface{}{"externalName": externalName},
+	})
 	if err != nil {
-		return fmt.Errorf("fetch external services: %w", err)
+		return err
 	}
-
-	for _, service := range services {
-		if c.electionClient.IsLeader() && c.shouldHandleService(service) && service.Spec.ExternalName != c.publicIP.String() {
-			klog.Infof("update service %s/%s external name to %s", service.Namespace, service.Name, c.publicIP.String())
-			updateService := service.DeepCopy()
-			updateService.Spec.ExternalName = c.publicIP.String()
-			_, err := c.clientset.CoreV1().Services(service.Namespace).Update(ctx, updateService, metav1.UpdateOptions{})
-			if err != nil {
-				return fmt.Errorf("update service %s/%s: %w", service.Namespace, service.Name, err)
-			}
-		}
+	// patch only the fields owned by the controller without the resourceVersion, so that never
+	// clobber the concurrent edits, and never conflict when patched from a stale informer copy
+	_, err = s.controller.clientset.CoreV1().Services(service.Namespace).Patch(ctx, service.Name, types.StrategicMergePatchType,
+		patch, metav1.PatchOptions{FieldManager: FieldManager})
+	if err != nil {
+		s.controller.recorder.Eventf(service, corev1.EventTypeWarning, EventReasonUpdateFailed, "Failed to update external name to %s: %s", externalName, err)
+		return fmt.Errorf("patch service %s/%s: %w", service.Namespace, service.Name, err)
 	}
+	s.controller.recorder.Eventf(service, corev1.EventTypeNormal, EventReasonUpdated, "Updated external name to %s", externalName)
 	return nil
 }
 
-func (c *Controller) fetchExternalServices(namespacedName types.NamespacedName) ([]*corev1.Service, error) {
-	if namespacedName.Namespace == "" && namespacedName.Name == "" {
-		objects, err := c.serviceLister.ByIndex(matchExternalServiceIndex, matchExternalServiceIndexValue)
-		services := make([]*corev1.Service, 0, len(objects))
-		for _, obj := range objects {
-			services = append(services, obj.(*corev1.Service))
-		}
-		return services, err
-	}
-
-	service, exists, err := c.serviceLister.GetByKey(namespacedName.String())
-	if !exists || err != nil {
-		return nil, err
+func (c *Controller) fetchExternalServices() ([]*corev1.Service, error) {
+	objects, err := c.serviceLister.ByIndex(matchExternalServiceIndex, matchExternalServiceIndexValue)
+	services := make([]*corev1.Service, 0, len(objects))
+	for _, obj := range objects {
+		services = append(services, obj.(*corev1.Service))
 	}
-	return []*corev1.Service{service.(*corev1.Service)}, nil
+	return services, err
 }
@@ -2,14 +2,31 @@ package service_test
 
 import (
 	"context"
+	"encoding/json"
+	"fmt"
+	"net"
 	"time"
 
 	. "github.com/onsi/ginkgo/v2"
 	. "github.com/onsi/gomega"
+	"go.uber.org/atomic"
 	corev1 "k8s.io/api/core/v1"
+	discoveryv1 "k8s.io/api/discovery/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	k8sruntime "k8s.io/apimachinery/pkg/runtime"
 	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/util/intstr"
 	"k8s.io/apimachinery/pkg/util/rand"
+	"k8s.io/client-go/informers"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/kubernetes/fake"
+	k8stesting "k8s.io/client-go/testing"
+	"k8s.io/component-base/metrics/legacyregistry"
+
+	"github.com/everoute/runtime/pkg/controller/service"
+	"github.com/everoute/runtime/pkg/options"
+	. "github.com/everoute/runtime/pkg/util/testing"
 )
 
 var _ = Describe("Service Reconcile", func() {
@@ -58,6 +75,49 @@ var _ = Describe("Service Reconcile", func() {
 			Expect(service.Spec.ExternalName).ShouldNot(Equal(publicIP.String()))
 		})
 
+		It("should annotate leader identity and update time on include service", func() {
+			Eventually(func() map[string]string {
+				service, err := clientset.CoreV1().Services(service01.Namespace).Get(ctx, service01.Name, metav1.GetOptions{})
+				Expect(err).ShouldNot(HaveOccurred())
+				return service.Annotations
+			}, testTimeout).Should(And(
+				HaveKeyWithValue(service.AnnotationLeaderIdentity, name),
+				HaveKeyWithValue(service.AnnotationLeaderUpdatedAt, WithTransform(func(s string) error {
+					_, err := time.Parse(time.RFC3339, s)
+					return err
+				}, Succeed())),
+			))
+		})
+
+		It("should record updated event on include service", func() {
+			Eventually(func() []corev1.Event {
+				events, err := clientset.CoreV1().Events(service01.Namespace).List(ctx, metav1.ListOptions{})
+				Expect(err).ShouldNot(HaveOccurred())
+				return events.Items
+			}, testTimeout).Should(ContainElement(And(
+				HaveField("InvolvedObject.Name", service01.Name),
+				HaveField("Reason", service.EventReasonUpdated),
+				HaveField("Type", corev1.EventTypeNormal),
+			)))
+		})
+
+		It("should expose reconcile metrics", func() {
+			Eventually(func() []string {
+				families, err := legacyregistry.DefaultGatherer.Gather()
+				Expect(err).ShouldNot(HaveOccurred())
+				var names []string
+				for _, family := range families {
+					names = append(names, family.GetName())
+				}
+				return names
+			}, testTimeout).Should(ContainElements(
+				"leader_target_reconcile_total",
+				"leader_target_reconcile_duration_seconds",
+				"workqueue_depth",
+				"workqueue_retries_total",
+			))
+		})
+
 		When("no-longer leading", func() {
 			BeforeEach(func() {
 				electionClient.SetLeader(rand.String(20))
@@ -111,6 +171,478 @@ var _ = Describe("Service Reconcile", func() {
 	})
 })
 
+var _ = Describe("Service Selector", func() {
+	var ctx context.Context
+	var cancel func()
+
+	BeforeEach(func() {
+		ctx, cancel = context.WithTimeout(context.Background(), time.Duration(testTimeout)*time.Second)
+		electionClient.SetLeader(name)
+	})
+	AfterEach(func() { cancel() })
+
+	newService := func(namespace string, labels, annotations map[string]string) *corev1.Service {
+		service := new(corev1.Service)
+		service.SetNamespace(namespace)
+		service.SetName(rand.String(20))
+		service.SetLabels(labels)
+		service.SetAnnotations(annotations)
+		service.Spec.Type = corev1.ServiceTypeExternalName
+		service.Spec.ExternalName = "127.0.0.1"
+		service, err := clientset.CoreV1().Services(namespace).Create(ctx, service, metav1.CreateOptions{})
+		Expect(err).ShouldNot(HaveOccurred())
+		DeferCleanup(func() {
+			Expect(clientset.CoreV1().Services(namespace).Delete(context.Background(), service.Name, metav1.DeleteOptions{})).ShouldNot(HaveOccurred())
+		})
+		return service
+	}
+	getExternalName := func(service *corev1.Service) func() string {
+		return func() string {
+			service, err := clientset.CoreV1().Services(service.Namespace).Get(ctx, service.Name, metav1.GetOptions{})
+			Expect(err).ShouldNot(HaveOccurred())
+			return service.Spec.ExternalName
+		}
+	}
+
+	It("should update external name on service matched by labels", func() {
+		externalService := newService(selectedNamespace, selectedLabels, nil)
+		Eventually(getExternalName(externalService), testTimeout).Should(Equal(publicIP.String()))
+	})
+
+	It("should update external name on service with the annotation", func() {
+		externalService := newService(selectedNamespace, nil, map[string]string{service.AnnotationLeaderExternalName: "true"})
+		Eventually(getExternalName(externalService), testTimeout).Should(Equal(publicIP.String()))
+	})
+
+	It("should not update external name on service out of the namespaces", func() {
+		externalService := newService(rand.String(20), selectedLabels, map[string]string{service.AnnotationLeaderExternalName: "true"})
+		Consistently(getExternalName(externalService), 2*time.Second).ShouldNot(Equal(publicIP.String()))
+	})
+
+	It("should update external name to the hostname selected by annotation", func() {
+		externalService := newService(selectedNamespace, selectedLabels, map[string]string{service.AnnotationLeaderTarget: service.TargetHostname})
+		Eventually(getExternalName(externalService), testTimeout).Should(Equal("leader.unittest.local"))
+	})
+
+	It("should update external name to the node annotation selected by annotation", func() {
+		externalService := newService(selectedNamespace, selectedLabels, map[string]string{
+			service.AnnotationLeaderTarget: service.TargetNodeAnnotationPrefix + "everoute.io/storage-ip",
+		})
+		Eventually(getExternalName(externalService), testTimeout).Should(Equal("10.2.0.1"))
+	})
+
+	It("should update external name when the node annotation changed", func() {
+		setNodeAnnotation := func(key, value string) {
+			node, err := clientset.CoreV1().Nodes().Get(ctx, nodeName, metav1.GetOptions{})
+			Expect(err).ShouldNot(HaveOccurred())
+			node.Annotations[key] = value
+			_, err = clientset.CoreV1().Nodes().Update(ctx, node, metav1.UpdateOptions{})
+			Expect(err).ShouldNot(HaveOccurred())
+		}
+		setNodeAnnotation("everoute.io/backup-ip", "10.2.0.2")
+
+		externalService := newService(selectedNamespace, selectedLabels, map[string]string{
+			service.AnnotationLeaderTarget: service.TargetNodeAnnotationPrefix + "everoute.io/backup-ip",
+		})
+		Eventually(getExternalName(externalService), testTimeout).Should(Equal("10.2.0.2"))
+
+		setNodeAnnotation("everoute.io/backup-ip", "10.2.0.3")
+		Eventually(getExternalName(externalService), testTimeout).Should(Equal("10.2.0.3"))
+	})
+
+	It("should not update external name on service with invalid target", func() {
+		externalService := newService(selectedNamespace, selectedLabels, map[string]string{service.AnnotationLeaderTarget: "unknown"})
+		Consistently(getExternalName(externalService), 2*time.Second).Should(Equal("127.0.0.1"))
+	})
+
+	It("should record warning event on service with invalid target", func() {
+		externalService := newService(selectedNamespace, selectedLabels, map[string]string{service.AnnotationLeaderTarget: "unknown"})
+		Eventually(func() []corev1.Event {
+			events, err := clientset.CoreV1().Events(externalService.Namespace).List(ctx, metav1.ListOptions{})
+			Expect(err).ShouldNot(HaveOccurred())
+			return events.Items
+		}, testTimeout).Should(ContainElement(And(
+			HaveField("InvolvedObject.Name", externalService.Name),
+			HaveField("Reason", service.EventReasonInvalidTarget),
+			HaveField("Type", corev1.EventTypeWarning),
+		)))
+	})
+
+	It("should update external name when service labels added", func() {
+		externalService := newService(selectedNamespace, nil, nil)
+		Consistently(getExternalName(externalService), time.Second).Should(Equal("127.0.0.1"))
+
+		externalService.SetLabels(selectedLabels)
+		_, err := clientset.CoreV1().Services(selectedNamespace).Update(ctx, externalService, metav1.UpdateOptions{})
+		Expect(err).ShouldNot(HaveOccurred())
+		Eventually(getExternalName(externalService), testTimeout).Should(Equal(publicIP.String()))
+	})
+
+	It("should update external name when service annotation added", func() {
+		externalService := newService(selectedNamespace, nil, nil)
+		Consistently(getExternalName(externalService), time.Second).Should(Equal("127.0.0.1"))
+
+		externalService.SetAnnotations(map[string]string{service.AnnotationLeaderExternalName: "true"})
+		_, err := clientset.CoreV1().Services(selectedNamespace).Update(ctx, externalService, metav1.UpdateOptions{})
+		Expect(err).ShouldNot(HaveOccurred())
+		Eventually(getExternalName(externalService), testTimeout).Should(Equal(publicIP.String()))
+	})
+
+	It("should update external name when invalid target annotation fixed", func() {
+		externalService := newService(selectedNamespace, selectedLabels, map[string]string{service.AnnotationLeaderTarget: "unknown"})
+		Consistently(getExternalName(externalService), time.Second).Should(Equal("127.0.0.1"))
+
+		externalService.SetAnnotations(map[string]string{service.AnnotationLeaderTarget: service.TargetHostname})
+		_, err := clientset.CoreV1().Services(selectedNamespace).Update(ctx, externalService, metav1.UpdateOptions{})
+		Expect(err).ShouldNot(HaveOccurred())
+		Eventually(getExternalName(externalService), testTimeout).Should(Equal("leader.unittest.local"))
+	})
+
+	It("should update external name when service type changed to ExternalName", func() {
+		externalService := newService(selectedNamespace, selectedLabels, nil)
+		Eventually(getExternalName(externalService), testTimeout).Should(Equal(publicIP.String()))
+
+		externalService, err := clientset.CoreV1().Services(selectedNamespace).Get(ctx, externalService.Name, metav1.GetOptions{})
+		Expect(err).ShouldNot(HaveOccurred())
+		externalService.Spec.Type, externalService.Spec.ExternalName = corev1.ServiceTypeClusterIP, ""
+		externalService.Spec.Selector = map[string]string{"app": "unittest"}
+		externalService, err = clientset.CoreV1().Services(selectedNamespace).Update(ctx, externalService, metav1.UpdateOptions{})
+		Expect(err).ShouldNot(HaveOccurred())
+		Consistently(getExternalName(externalService), time.Second).Should(BeEmpty())
+
+		externalService.Spec.Type, externalService.Spec.ExternalName = corev1.ServiceTypeExternalName, "127.0.0.1"
+		_, err = clientset.CoreV1().Services(selectedNamespace).Update(ctx, externalService, metav1.UpdateOptions{})
+		Expect(err).ShouldNot(HaveOccurred())
+		Eventually(getExternalName(externalService), testTimeout).Should(Equal(publicIP.String()))
+	})
+
+	It("should update external name when service recreated", func() {
+		externalService := newService(selectedNamespace, selectedLabels, nil)
+		Eventually(getExternalName(externalService), testTimeout).Should(Equal(publicIP.String()))
+
+		Expect(clientset.CoreV1().Services(selectedNamespace).Delete(ctx, externalService.Name, metav1.DeleteOptions{})).ShouldNot(HaveOccurred())
+		externalService.ResourceVersion, externalService.Spec.ExternalName = "", "127.0.0.1"
+		_, err := clientset.CoreV1().Services(selectedNamespace).Create(ctx, externalService, metav1.CreateOptions{})
+		Expect(err).ShouldNot(HaveOccurred())
+		Eventually(getExternalName(externalService), testTimeout).Should(Equal(publicIP.String()))
+	})
+
+	It("should not update external name on service not selected", func() {
+		externalService := newService(selectedNamespace, map[string]string{"everoute.io/unittest": "unselected"}, nil)
+		Consistently(getExternalName(externalService), 2*time.Second).ShouldNot(Equal(publicIP.String()))
+	})
+})
+
+var _ = Describe("Service EndpointSlice", func() {
+	var ctx context.Context
+	var cancel func()
+
+	BeforeEach(func() {
+		ctx, cancel = context.WithTimeout(context.Background(), time.Duration(testTimeout)*time.Second)
+		electionClient.SetLeader(name)
+	})
+	AfterEach(func() { cancel() })
+
+	newClusterIPService := func(selector, annotations map[string]string) *corev1.Service {
+		clusterIPService := new(corev1.Service)
+		clusterIPService.SetNamespace(selectedNamespace)
+		clusterIPService.SetName(rand.String(20))
+		clusterIPService.SetLabels(selectedLabels)
+		clusterIPService.SetAnnotations(annotations)
+		clusterIPService.Spec.Type = corev1.ServiceTypeClusterIP
+		clusterIPService.Spec.Selector = selector
+		clusterIPService.Spec.Ports = []corev1.ServicePort{
+			{Name: "https", Protocol: corev1.ProtocolTCP, Port: 443, TargetPort: intstr.FromInt(9443)},
+		}
+		clusterIPService, err := clientset.CoreV1().Services(selectedNamespace).Create(ctx, clusterIPService, metav1.CreateOptions{})
+		Expect(err).ShouldNot(HaveOccurred())
+		DeferCleanup(func() {
+			Expect(clientset.CoreV1().Services(selectedNamespace).Delete(context.Background(), clusterIPService.Name, metav1.DeleteOptions{})).ShouldNot(HaveOccurred())
+		})
+		return clusterIPService
+	}
+	getEndpointSlice := func(clusterIPService *corev1.Service) func() (*discoveryv1.EndpointSlice, error) {
+		return func() (*discoveryv1.EndpointSlice, error) {
+			return clientset.DiscoveryV1().EndpointSlices(clusterIPService.Namespace).Get(ctx, service.EndpointSliceName(clusterIPService.Name), metav1.GetOptions{})
+		}
+	}
+
+	It("should publish leader endpoint on service without selector", func() {
+		clusterIPService := newClusterIPService(nil, nil)
+		Eventually(getEndpointSlice(clusterIPService), testTimeout).Should(And(
+			HaveField("Labels", HaveKeyWithValue(discoveryv1.LabelServiceName, clusterIPService.Name)),
+			HaveField("AddressType", discoveryv1.AddressTypeIPv4),
+			HaveField("Endpoints", ConsistOf(HaveField("Addresses", ConsistOf(publicIP.String())))),
+			HaveField("Ports", ConsistOf(HaveField("Port", HaveValue(BeEquivalentTo(9443))))),
+		))
+	})
+
+	It("should update ports when service ports changed", func() {
+		clusterIPService := newClusterIPService(nil, nil)
+		Eventually(getEndpointSlice(clusterIPService), testTimeout).ShouldNot(BeNil())
+
+		clusterIPService.Spec.Ports[0].TargetPort = intstr.FromInt(10443)
+		_, err := clientset.CoreV1().Services(clusterIPService.Namespace).Update(ctx, clusterIPService, metav1.UpdateOptions{})
+		Expect(err).ShouldNot(HaveOccurred())
+		Eventually(getEndpointSlice(clusterIPService), testTimeout).
+			Should(HaveField("Ports", ConsistOf(HaveField("Port", HaveValue(BeEquivalentTo(10443))))))
+	})
+
+	It("should delete endpointslice when service no longer matched", func() {
+		clusterIPService := newClusterIPService(nil, nil)
+		Eventually(getEndpointSlice(clusterIPService), testTimeout).ShouldNot(BeNil())
+
+		clusterIPService.SetLabels(nil)
+		_, err := clientset.CoreV1().Services(clusterIPService.Namespace).Update(ctx, clusterIPService, metav1.UpdateOptions{})
+		Expect(err).ShouldNot(HaveOccurred())
+		Eventually(func() bool {
+			_, err := getEndpointSlice(clusterIPService)()
+			return apierrors.IsNotFound(err)
+		}, testTimeout).Should(BeTrue())
+	})
+
+	It("should delete endpointslice when service deleted", func() {
+		clusterIPService := &corev1.Service{
+			ObjectMeta: metav1.ObjectMeta{Namespace: selectedNamespace, Name: rand.String(20), Labels: selectedLabels},
+			Spec:       corev1.ServiceSpec{Type: corev1.ServiceTypeClusterIP, Ports: []corev1.ServicePort{{Name: "https", Port: 443}}},
+		}
+		clusterIPService, err := clientset.CoreV1().Services(selectedNamespace).Create(ctx, clusterIPService, metav1.CreateOptions{})
+		Expect(err).ShouldNot(HaveOccurred())
+		Eventually(getEndpointSlice(clusterIPService), testTimeout).ShouldNot(BeNil())
+
+		Expect(clientset.CoreV1().Services(selectedNamespace).Delete(ctx, clusterIPService.Name, metav1.DeleteOptions{})).ShouldNot(HaveOccurred())
+		Eventually(func() bool {
+			_, err := getEndpointSlice(clusterIPService)()
+			return apierrors.IsNotFound(err)
+		}, testTimeout).Should(BeTrue())
+	})
+
+	It("should not take over endpointslice managed by others", func() {
+		clusterIPService := &corev1.Service{
+			ObjectMeta: metav1.ObjectMeta{Namespace: selectedNamespace, Name: rand.String(20), Labels: selectedLabels},
+			Spec:       corev1.ServiceSpec{Type: corev1.ServiceTypeClusterIP, Ports: []corev1.ServicePort{{Name: "https", Port: 443}}},
+		}
+		_, err := clientset.DiscoveryV1().EndpointSlices(selectedNamespace).Create(ctx, &discoveryv1.EndpointSlice{
+			ObjectMeta: metav1.ObjectMeta{
+				Namespace: selectedNamespace,
+				Name:      service.EndpointSliceName(clusterIPService.Name),
+				Labels:    map[string]string{discoveryv1.LabelManagedBy: "others.unittest"},
+			},
+			AddressType: discoveryv1.AddressTypeIPv4,
+			Endpoints:   []discoveryv1.Endpoint{{Addresses: []string{"10.3.0.1"}}},
+		}, metav1.CreateOptions{})
+		Expect(err).ShouldNot(HaveOccurred())
+		clusterIPService, err = clientset.CoreV1().Services(selectedNamespace).Create(ctx, clusterIPService, metav1.CreateOptions{})
+		Expect(err).ShouldNot(HaveOccurred())
+		DeferCleanup(func() {
+			Expect(clientset.CoreV1().Services(selectedNamespace).Delete(context.Background(), clusterIPService.Name, metav1.DeleteOptions{})).ShouldNot(HaveOccurred())
+		})
+
+		Eventually(func() []corev1.Event {
+			events, err := clientset.CoreV1().Events(clusterIPService.Namespace).List(ctx, metav1.ListOptions{})
+			Expect(err).ShouldNot(HaveOccurred())
+			return events.Items
+		}, testTimeout).Should(ContainElement(And(
+			HaveField("InvolvedObject.Name", clusterIPService.Name),
+			HaveField("Reason", service.EventReasonUpdateFailed),
+			HaveField("Type", corev1.EventTypeWarning),
+		)))
+		Consistently(getEndpointSlice(clusterIPService), time.Second).Should(And(
+			HaveField("Labels", HaveKeyWithValue(discoveryv1.LabelManagedBy, "others.unittest")),
+			HaveField("Endpoints", ConsistOf(HaveField("Addresses", ConsistOf("10.3.0.1")))),
+		))
+	})
+
+	It("should not publish hostname target on service without selector", func() {
+		clusterIPService := newClusterIPService(nil, map[string]string{service.AnnotationLeaderTarget: service.TargetHostname})
+		Consistently(func() bool {
+			_, err := getEndpointSlice(clusterIPService)()
+			return apierrors.IsNotFound(err)
+		}, 2*time.Second).Should(BeTrue())
+	})
+
+	It("should not publish leader endpoint on service with selector", func() {
+		clusterIPService := newClusterIPService(map[string]string{"app": "unittest"}, nil)
+		Consistently(func() bool {
+			_, err := getEndpointSlice(clusterIPService)()
+			return apierrors.IsNotFound(err)
+		}, 2*time.Second).Should(BeTrue())
+	})
+})
+
+var _ = Describe("Service Resync", func() {
+	It("should repair endpointslice deleted by others when resync", func() {
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+
+		clusterIPService := &corev1.Service{
+			ObjectMeta: metav1.ObjectMeta{
+				Namespace:   rand.String(20),
+				Name:        rand.String(20),
+				Annotations: map[string]string{service.AnnotationLeaderExternalName: "true"},
+			},
+			Spec: corev1.ServiceSpec{
+				Type:  corev1.ServiceTypeClusterIP,
+				Ports: []corev1.ServicePort{{Name: "https", Protocol: corev1.ProtocolTCP, Port: 443}},
+			},
+		}
+		resyncClientset := fake.NewSimpleClientset(clusterIPService)
+		client := NewFakeLeaderElectionClient(rand.String(20))
+		client.SetLeader(client.Identity())
+		factory := informers.NewSharedInformerFactory(resyncClientset, 0)
+		controller := service.NewWithConfig(resyncClientset, factory, client, publicIP, service.Config{
+			ResyncPeriod:         500 * time.Millisecond,
+			EndpointSliceEnabled: true,
+		})
+		go controller.Run(ctx.Done())
+		factory.Start(ctx.Done())
+		factory.WaitForCacheSync(ctx.Done())
+
+		endpointSlices := resyncClientset.DiscoveryV1().EndpointSlices(clusterIPService.Namespace)
+		getEndpointSlice := func() error {
+			_, err := endpointSlices.Get(ctx, service.EndpointSliceName(clusterIPService.Name), metav1.GetOptions{})
+			return err
+		}
+		Eventually(getEndpointSlice, testTimeout).Should(Succeed())
+		Expect(endpointSlices.Delete(ctx, service.EndpointSliceName(clusterIPService.Name), metav1.DeleteOptions{})).ShouldNot(HaveOccurred())
+		Eventually(getEndpointSlice, testTimeout).Should(Succeed())
+	})
+})
+
+var _ = Describe("Service Release", func() {
+	var ctx context.Context
+	var cancel func()
+	var releaseClientset kubernetes.Interface
+	var externalService *corev1.Service
+
+	// runController starts a replica with identity in format of address_suffix
+	runController := func(client options.LeaderElectionClient, config service.Config) {
+		address := options.NodeAddressFromIdentity(client.Identity())
+		factory := informers.NewSharedInformerFactory(releaseClientset, 0)
+		controller := service.NewWithConfig(releaseClientset, factory, client, address, config)
+		go controller.Run(ctx.Done())
+		factory.Start(ctx.Done())
+		factory.WaitForCacheSync(ctx.Done())
+	}
+	newIdentity := func(address string) string { return address + "_" + rand.String(10) }
+	getExternalName := func() string {
+		service, err := releaseClientset.CoreV1().Services(externalService.Namespace).Get(ctx, externalService.Name, metav1.GetOptions{})
+		Expect(err).ShouldNot(HaveOccurred())
+		return service.Spec.ExternalName
+	}
+
+	BeforeEach(func() {
+		var err error
+		ctx, cancel = context.WithCancel(context.Background())
+		releaseClientset = fake.NewSimpleClientset()
+		externalService = &corev1.Service{
+			ObjectMeta: metav1.ObjectMeta{
+				Namespace:   rand.String(20),
+				Name:        rand.String(20),
+				Annotations: map[string]string{service.AnnotationLeaderExternalName: "true"},
+			},
+			Spec: corev1.ServiceSpec{Type: corev1.ServiceTypeExternalName, ExternalName: "127.0.0.1"},
+		}
+		externalService, err = releaseClientset.CoreV1().Services(externalService.Namespace).Create(ctx, externalService, metav1.CreateOptions{})
+		Expect(err).ShouldNot(HaveOccurred())
+	})
+	AfterEach(func() { cancel() })
+
+	It("should clear external name when the lease released", func() {
+		client := NewFakeLeaderElectionClient(newIdentity("10.1.0.1"))
+		client.SetLeader(client.Identity())
+		runController(client, service.Config{ClearOnRelease: true, ReleasedExternalName: "localhost"})
+		Eventually(getExternalName, testTimeout).Should(Equal("10.1.0.1"))
+
+		Expect(client.Release(ctx)).ShouldNot(HaveOccurred())
+		Eventually(getExternalName, testTimeout).Should(Equal("localhost"))
+	})
+
+	It("should clear external name when the lease of the election released", func() {
+		client := NewElectionClient(releaseClientset, net.ParseIP("10.1.0.1"), ctx.Done(), "--election-retry-period=100ms")
+		Eventually(client.IsLeader, testTimeout).Should(BeTrue())
+		runController(client, service.Config{ClearOnRelease: true, ReleasedExternalName: "localhost"})
+		Eventually(getExternalName, testTimeout).Should(Equal("10.1.0.1"))
+
+		Expect(client.(options.Releaser).Release(ctx)).ShouldNot(HaveOccurred())
+		Eventually(getExternalName, testTimeout).Should(Equal("localhost"))
+	})
+
+	It("should hand over external name when another node leading", func() {
+		client := NewFakeLeaderElectionClient(newIdentity("10.1.0.1"))
+		client.SetLeader(client.Identity())
+		runController(client, service.Config{ClearOnRelease: true, ReleasedExternalName: "localhost"})
+		Eventually(getExternalName, testTimeout).Should(Equal("10.1.0.1"))
+
+		client.SetLeader(newIdentity("10.1.0.2"))
+		Consistently(getExternalName, 2*time.Second).Should(Equal("10.1.0.1"))
+	})
+
+	It("should fix stale external name to the lease holder from follower", func() {
+		client := NewFakeLeaderElectionClient(newIdentity("10.1.0.2"))
+		client.SetLeader(newIdentity("10.1.0.1"))
+		runController(client, service.Config{StalenessGuard: true})
+		Eventually(getExternalName, testTimeout).Should(Equal("10.1.0.1"))
+	})
+
+	It("should not update external name from follower without staleness guard", func() {
+		client := NewFakeLeaderElectionClient(newIdentity("10.1.0.2"))
+		client.SetLeader(newIdentity("10.1.0.1"))
+		runController(client, service.Config{})
+		Consistently(getExternalName, 2*time.Second).Should(Equal("127.0.0.1"))
+	})
+})
+
+var _ = Describe("Service Conflict", func() {
+	It("should patch external name without conflict when the observed service stale", func() {
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+
+		externalService := &corev1.Service{
+			ObjectMeta: metav1.ObjectMeta{
+				Namespace:       rand.String(20),
+				Name:            rand.String(20),
+				ResourceVersion: "10",
+				Annotations:     map[string]string{service.AnnotationLeaderExternalName: "true"},
+			},
+			Spec: corev1.ServiceSpec{Type: corev1.ServiceTypeExternalName, ExternalName: "127.0.0.1"},
+		}
+		conflictClientset := fake.NewSimpleClientset(externalService)
+
+		// the service has been changed by others since observed, the patch with
+		// the observed resourceVersion conflicts as the apiserver does
+		var patched, conflicts atomic.Int32
+		conflictClientset.PrependReactor("patch", "services", func(action k8stesting.Action) (bool, k8sruntime.Object, error) {
+			var patch struct {
+				Metadata metav1.ObjectMeta `json:"metadata"`
+			}
+			Expect(json.Unmarshal(action.(k8stesting.PatchAction).GetPatch(), &patch)).ShouldNot(HaveOccurred())
+			patched.Inc()
+			if patch.Metadata.ResourceVersion != "" && patch.Metadata.ResourceVersion != "11" {
+				conflicts.Inc()
+				return true, nil, apierrors.NewConflict(corev1.Resource("services"), externalService.Name, fmt.Errorf("modified"))
+			}
+			return false, nil, nil
+		})
+
+		client := NewFakeLeaderElectionClient(rand.String(20))
+		client.SetLeader(client.Identity())
+		factory := informers.NewSharedInformerFactory(conflictClientset, 0)
+		controller := service.NewWithConfig(conflictClientset, factory, client, publicIP, service.Config{BaseBackoff: 10 * time.Millisecond})
+		go controller.Run(ctx.Done())
+		factory.Start(ctx.Done())
+		factory.WaitForCacheSync(ctx.Done())
+
+		Eventually(func() string {
+			service, err := conflictClientset.CoreV1().Services(externalService.Namespace).Get(ctx, externalService.Name, metav1.GetOptions{})
+			Expect(err).ShouldNot(HaveOccurred())
+			return service.Spec.ExternalName
+		}, testTimeout).Should(Equal(publicIP.String()))
+		Expect(conflicts.Load()).Should(BeZero())
+		Expect(patched.Load()).ShouldNot(BeZero())
+	})
+})
+
 func createExternalService(ctx context.Context, namespacedName types.NamespacedName) (*corev1.Service, error) {
 	service := new(corev1.Service)
 	service.SetNamespace(namespacedName.Namespace)
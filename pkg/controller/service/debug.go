@@ -0,0 +1,124 @@
+package service
+
+import (
+	"encoding/json"
+	"net/http"
+	"sort"
+	"sync"
+
+	"github.com/samber/lo"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+)
+
+// DebugPath is the recommended path to install the controller as the debug handler, e.g.
+// manager.RegisterDebugHandler(service.DebugPath, controller), served with --controller-debug-handlers
+const DebugPath = "/debug/services"
+
+// ServiceStatus is the reconcile status of a matched service
+type ServiceStatus struct {
+	Namespace string             `json:"namespace"`
+	Name      string             `json:"name"`
+	Type      corev1.ServiceType `json:"type"`
+	// Current is the current target, the ExternalName or the address in the EndpointSlice
+	Current string `json:"current"`
+	// Desired is the target this node would publish, empty if cleared or kept unchanged, e.g. on followers
+	Desired           string       `json:"desired,omitempty"`
+	DryRun            bool         `json:"dryRun,omitempty"`
+	LastReconcileTime *metav1.Time `json:"lastReconcileTime,omitempty"`
+	LastError         string       `json:"lastError,omitempty"`
+}
+
+// statusStore records the last reconcile status of the services, safe for parallel workers
+type statusStore struct {
+	lock   sync.Mutex
+	status map[types.NamespacedName]ServiceStatus
+}
+
+func (s *statusStore) setTarget(service *corev1.Service, current, desired string, dryRun bool) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	key := types.NamespacedName{Namespace: service.Namespace, Name: service.Name}
+	status := s.status[key]
+	status.Current, status.Desired, status.DryRun = current, desired, dryRun
+	s.status[key] = status
+}
+
+func (s *statusStore) setResult(key types.NamespacedName, err error) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	status := s.status[key]
+	status.LastReconcileTime = lo.ToPtr(metav1.Now())
+	status.LastError = lo.TernaryF(err == nil, func() string { return "" }, func() string { return err.Error() })
+	s.status[key] = status
+}
+
+func (s *statusStore) get(key types.NamespacedName) (ServiceStatus, bool) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	status, ok := s.status[key]
+	return status, ok
+}
+
+func (s *statusStore) delete(key types.NamespacedName) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	delete(s.status, key)
+}
+
+// Status returns status of the services matched by the controller, sorted by namespace and name
+func (c *Controller) Status() ([]ServiceStatus, error) {
+	services, err := c.fetchExternalServices()
+	if err != nil {
+		return nil, err
+	}
+
+	statuses := make([]ServiceStatus, 0, len(services))
+	for _, service := range services {
+		key := types.NamespacedName{Namespace: service.Namespace, Name: service.Name}
+		status, ok := c.status.get(key)
+		if !ok && service.Spec.Type == corev1.ServiceTypeExternalName {
+			status.Current = service.Spec.ExternalName // not reconciled yet
+		}
+		status.Namespace, status.Name, status.Type = service.Namespace, service.Name, service.Spec.Type
+		statuses = append(statuses, status)
+	}
+	sort.Slice(statuses, func(i, j int) bool {
+		if statuses[i].Namespace != statuses[j].Namespace {
+			return statuses[i].Namespace < statuses[j].Namespace
+		}
+		return statuses[i].Name < statuses[j].Name
+	})
+	return statuses, nil
+}
+
+// ServeHTTP writes the status of the matched services in json
+func (c *Controller) ServeHTTP(w http.ResponseWriter, _ *http.Request) {
+	statuses, err := c.Status()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(statuses)
+}
+
+// desiredTargetOf returns the target which should be published of the service, empty means the
+// target should be cleared, ok is false if the target should be kept unchanged by this node. In
+// dry-run mode, the target of this node is always returned as if leading.
+func (c *Controller) desiredTargetOf(service *corev1.Service, current string) (target string, ok bool, err error) {
+	own, override, err := c.resolveTarget(service)
+	if err != nil {
+		if c.electionClient.IsLeader() || c.config.DryRun {
+			return "", false, err
+		}
+		own = "" // followers need not their own targets except for clearing
+	}
+	if c.config.DryRun {
+		return own, true, nil
+	}
+	// targets of other nodes could not be resolved when overridden by annotation
+	target, ok = c.resolver.desiredTarget(current, own, lo.Ternary(override, nil, addressOfIdentity))
+	return target, ok, nil
+}
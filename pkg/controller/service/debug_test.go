@@ -0,0 +1,155 @@
+package service_test
+
+import (
+	"context"
+	"encoding/json"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"time"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/rand"
+	"k8s.io/client-go/informers"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/kubernetes/fake"
+
+	"github.com/everoute/runtime/pkg/controller/service"
+	. "github.com/everoute/runtime/pkg/util/testing"
+)
+
+var _ = Describe("Service Dry Run", func() {
+	var ctx context.Context
+	var cancel func()
+	var dryRunClientset kubernetes.Interface
+	var controller *service.Controller
+	var namespace string
+
+	createService := func(annotations map[string]string) *corev1.Service {
+		externalService := &corev1.Service{
+			ObjectMeta: metav1.ObjectMeta{Namespace: namespace, Name: rand.String(20), Annotations: annotations},
+			Spec:       corev1.ServiceSpec{Type: corev1.ServiceTypeExternalName, ExternalName: "127.0.0.1"},
+		}
+		externalService, err := dryRunClientset.CoreV1().Services(namespace).Create(ctx, externalService, metav1.CreateOptions{})
+		Expect(err).ShouldNot(HaveOccurred())
+		return externalService
+	}
+
+	BeforeEach(func() {
+		ctx, cancel = context.WithCancel(context.Background())
+		dryRunClientset = fake.NewSimpleClientset()
+		namespace = rand.String(20)
+
+		factory := informers.NewSharedInformerFactory(dryRunClientset, 0)
+		client := NewFakeLeaderElectionClient("10.1.0.1_" + rand.String(10))
+		client.SetLeader("10.1.0.2_" + rand.String(10))
+		controller = service.NewWithConfig(dryRunClientset, factory, client, net.ParseIP("10.1.0.1"), service.Config{
+			Namespaces: []string{namespace},
+			DryRun:     true,
+		})
+		go controller.Run(ctx.Done())
+		factory.Start(ctx.Done())
+		factory.WaitForCacheSync(ctx.Done())
+	})
+	AfterEach(func() { cancel() })
+
+	It("should report desired target without update the service", func() {
+		externalService := createService(map[string]string{service.AnnotationLeaderExternalName: "true"})
+
+		Eventually(controller.Status, testTimeout).Should(ConsistOf(And(
+			HaveField("Name", externalService.Name),
+			HaveField("Current", "127.0.0.1"),
+			HaveField("Desired", "10.1.0.1"),
+			HaveField("DryRun", true),
+			HaveField("LastReconcileTime", Not(BeNil())),
+			HaveField("LastError", BeEmpty()),
+		)))
+		Consistently(func() string {
+			externalService, err := dryRunClientset.CoreV1().Services(namespace).Get(ctx, externalService.Name, metav1.GetOptions{})
+			Expect(err).ShouldNot(HaveOccurred())
+			return externalService.Spec.ExternalName
+		}, time.Second).Should(Equal("127.0.0.1"))
+	})
+
+	It("should report last reconcile error", func() {
+		externalService := createService(map[string]string{
+			service.AnnotationLeaderExternalName: "true",
+			service.AnnotationLeaderTarget:       service.TargetInterfacePrefix + rand.String(10),
+		})
+		Eventually(controller.Status, testTimeout).Should(ConsistOf(And(
+			HaveField("Name", externalService.Name),
+			HaveField("LastError", ContainSubstring("resolve target")),
+		)))
+	})
+
+	It("should serve status of matched services", func() {
+		externalService := createService(map[string]string{service.AnnotationLeaderExternalName: "true"})
+		createService(nil) // not matched
+
+		Eventually(func() []service.ServiceStatus {
+			recorder := httptest.NewRecorder()
+			controller.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, service.DebugPath, nil))
+			Expect(recorder.Code).Should(Equal(http.StatusOK))
+			var statuses []service.ServiceStatus
+			Expect(json.Unmarshal(recorder.Body.Bytes(), &statuses)).ShouldNot(HaveOccurred())
+			return statuses
+		}, testTimeout).Should(ConsistOf(And(
+			HaveField("Namespace", namespace),
+			HaveField("Name", externalService.Name),
+			HaveField("Type", corev1.ServiceTypeExternalName),
+			HaveField("Desired", "10.1.0.1"),
+		)))
+	})
+
+	It("should omit last reconcile time when not reconciled", func() {
+		raw, err := json.Marshal(service.ServiceStatus{Name: rand.String(20)})
+		Expect(err).ShouldNot(HaveOccurred())
+		Expect(string(raw)).ShouldNot(ContainSubstring("lastReconcileTime"))
+	})
+})
+
+var _ = Describe("Service Follower Status", func() {
+	It("should report observed target without update the service", func() {
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+		followerClientset := fake.NewSimpleClientset()
+		namespace := rand.String(20)
+
+		factory := informers.NewSharedInformerFactory(followerClientset, 0)
+		client := NewFakeLeaderElectionClient("10.1.0.1_" + rand.String(10))
+		client.SetLeader("10.1.0.2_" + rand.String(10))
+		controller := service.NewWithConfig(followerClientset, factory, client, net.ParseIP("10.1.0.1"), service.Config{
+			Namespaces: []string{namespace},
+		})
+		go controller.Run(ctx.Done())
+		factory.Start(ctx.Done())
+		factory.WaitForCacheSync(ctx.Done())
+
+		externalService, err := followerClientset.CoreV1().Services(namespace).Create(ctx, &corev1.Service{
+			ObjectMeta: metav1.ObjectMeta{
+				Namespace:   namespace,
+				Name:        rand.String(20),
+				Annotations: map[string]string{service.AnnotationLeaderExternalName: "true"},
+			},
+			Spec: corev1.ServiceSpec{Type: corev1.ServiceTypeExternalName, ExternalName: "10.1.0.2"},
+		}, metav1.CreateOptions{})
+		Expect(err).ShouldNot(HaveOccurred())
+
+		Eventually(controller.Status, testTimeout).Should(ConsistOf(And(
+			HaveField("Name", externalService.Name),
+			HaveField("Current", "10.1.0.2"),
+			HaveField("Desired", BeEmpty()),
+			HaveField("DryRun", false),
+			HaveField("LastReconcileTime", Not(BeNil())),
+			HaveField("LastError", BeEmpty()),
+		)))
+		Consistently(func() string {
+			externalService, err := followerClientset.CoreV1().Services(namespace).Get(ctx, externalService.Name, metav1.GetOptions{})
+			Expect(err).ShouldNot(HaveOccurred())
+			return externalService.Spec.ExternalName
+		}, time.Second).Should(Equal("10.1.0.2"))
+	})
+})
@@ -0,0 +1,197 @@
+package service
+
+import (
+	"context"
+	"fmt"
+	"net"
+
+	"github.com/samber/lo"
+	corev1 "k8s.io/api/core/v1"
+	discoveryv1 "k8s.io/api/discovery/v1"
+	"k8s.io/apimachinery/pkg/api/equality"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/util/intstr"
+	"k8s.io/klog/v2"
+)
+
+const (
+	// EndpointSliceManagedBy is the value of the label endpointslice.kubernetes.io/managed-by
+	// on the EndpointSlices maintained by the controller
+	EndpointSliceManagedBy = "leader-endpoint.everoute.io"
+	// endpointSliceNameSuffix is appended to the service name as the EndpointSlice name
+	endpointSliceNameSuffix = "-leader"
+)
+
+// EndpointSliceName returns name of the EndpointSlice maintained for the service
+func EndpointSliceName(serviceName string) string {
+	return serviceName + endpointSliceNameSuffix
+}
+
+func isSelectorlessClusterIP(service *corev1.Service) bool {
+	return (service.Spec.Type == "" || service.Spec.Type == corev1.ServiceTypeClusterIP) && len(service.Spec.Selector) == 0
+}
+
+// endpointSliceSink publishes the target into the EndpointSlice of the service, the EndpointSlice
+// contains only the target address, or no endpoints when the target cleared
+type endpointSliceSink struct {
+	controller *Controller
+	service    *corev1.Service
+	// existing is the EndpointSlice got by Current, nil if not found
+	existing *discoveryv1.EndpointSlice
+}
+
+func (s *endpointSliceSink) Name() string {
+	return fmt.Sprintf("endpointslice/%s/%s", s.service.Namespace, EndpointSliceName(s.service.Name))
+}
+
+func (s *endpointSliceSink) Current(ctx context.Context) (string, error) {
+	existing, err := s.controller.clientset.DiscoveryV1().EndpointSlices(s.service.Namespace).
+		Get(ctx, EndpointSliceName(s.service.Name), metav1.GetOptions{})
+	if apierrors.IsNotFound(err) {
+		s.existing = nil
+		return "", nil
+	}
+	if err != nil {
+		return "", err
+	}
+	s.existing = existing
+	if len(existing.Endpoints) != 0 && len(existing.Endpoints[0].Addresses) != 0 {
+		return existing.Endpoints[0].Addresses[0], nil
+	}
+	return "", nil
+}
+
+// changed returns true if the EndpointSlice differs from the expected one of the target
+func (s *endpointSliceSink) changed(_, target string) bool {
+	return s.actionOf(s.controller.newEndpointSlice(s.service, net.ParseIP(target))) != ""
+}
+
+// actionOf returns the action to make the existing EndpointSlice as the expect, empty if up-to-date
+func (s *endpointSliceSink) actionOf(expect *discoveryv1.EndpointSlice) string {
+	existing := s.existing
+	switch {
+	case existing == nil && len(expect.Endpoints) == 0:
+		return "" // never create an EndpointSlice without endpoints
+	case existing == nil:
+		return "create"
+	case len(expect.Endpoints) != 0 && existing.AddressType != expect.AddressType:
+		return "recreate" // address type is immutable
+	case !equality.Semantic.DeepEqual(existing.Endpoints, expect.Endpoints) ||
+		!equality.Semantic.DeepEqual(existing.Ports, expect.Ports) ||
+		!equality.Semantic.DeepEqual(existing.Labels, expect.Labels) ||
+		!equality.Semantic.DeepEqual(existing.OwnerReferences, expect.OwnerReferences):
+		return "update"
+	default:
+		return ""
+	}
+}
+
+func (s *endpointSliceSink) Publish(ctx context.Context, target string) error {
+	client := s.controller.clientset.DiscoveryV1().EndpointSlices(s.service.Namespace)
+	expect := s.controller.newEndpointSlice(s.service, net.ParseIP(target))
+
+	// never take over the EndpointSlice with the same name maintained by others, same as the cleanup
+	if s.existing != nil && s.existing.Labels[discoveryv1.LabelManagedBy] != EndpointSliceManagedBy {
+		s.controller.recorder.Eventf(s.service, corev1.EventTypeWarning, EventReasonUpdateFailed,
+			"Skipped endpointslice %s not managed by %s", expect.Name, EndpointSliceManagedBy)
+		return fmt.Errorf("endpointslice %s/%s not managed by %s", expect.Namespace, expect.Name, EndpointSliceManagedBy)
+	}
+
+	var err error
+	switch s.actionOf(expect) {
+	case "create":
+		_, err = client.Create(ctx, expect, metav1.CreateOptions{})
+	case "recreate":
+		err = client.Delete(ctx, expect.Name, metav1.DeleteOptions{Preconditions: &metav1.Preconditions{UID: &s.existing.UID}})
+		if err == nil {
+			_, err = client.Create(ctx, expect, metav1.CreateOptions{})
+		}
+	case "update":
+		update := s.existing.DeepCopy()
+		update.Labels, update.Endpoints, update.Ports = expect.Labels, expect.Endpoints, expect.Ports
+		// owner changes when the service recreated, update it so that never collected by the old owner
+		update.OwnerReferences = expect.OwnerReferences
+		_, err = client.Update(ctx, update, metav1.UpdateOptions{})
+	default:
+		return nil
+	}
+	if err != nil {
+		s.controller.recorder.Eventf(s.service, corev1.EventTypeWarning, EventReasonUpdateFailed,
+			"Failed to update endpointslice %s to %s: %s", expect.Name, displayTarget(target), err)
+		return fmt.Errorf("reconcile endpointslice %s/%s: %w", expect.Namespace, expect.Name, err)
+	}
+	s.controller.recorder.Eventf(s.service, corev1.EventTypeNormal, EventReasonUpdated, "Updated endpointslice %s to %s", expect.Name, displayTarget(target))
+	return nil
+}
+
+// cleanupEndpointSlice deletes the EndpointSlice maintained for the service, when the service
+// has been deleted or no longer matched
+func (c *Controller) cleanupEndpointSlice(ctx context.Context, namespacedName types.NamespacedName) error {
+	if !c.config.EndpointSliceEnabled || !c.electionClient.IsLeader() || c.config.DryRun {
+		return nil
+	}
+
+	client := c.clientset.DiscoveryV1().EndpointSlices(namespacedName.Namespace)
+	name := EndpointSliceName(namespacedName.Name)
+	existing, err := client.Get(ctx, name, metav1.GetOptions{})
+	if apierrors.IsNotFound(err) || (err == nil && existing.Labels[discoveryv1.LabelManagedBy] != EndpointSliceManagedBy) {
+		return nil
+	}
+	if err == nil {
+		klog.Infof("delete endpointslice %s/%s of unmatched service", namespacedName.Namespace, name)
+		err = client.Delete(ctx, name, metav1.DeleteOptions{Preconditions: &metav1.Preconditions{UID: &existing.UID}})
+	}
+	if err != nil && !apierrors.IsNotFound(err) {
+		return fmt.Errorf("cleanup endpointslice %s/%s: %w", namespacedName.Namespace, name, err)
+	}
+	return nil
+}
+
+// newEndpointSlice returns the EndpointSlice with the address, or without endpoints if the address is nil
+func (c *Controller) newEndpointSlice(service *corev1.Service, address net.IP) *discoveryv1.EndpointSlice {
+	addressType := discoveryv1.AddressTypeIPv4
+	if lo.Ternary(address != nil, address, c.publicIP).To4() == nil {
+		addressType = discoveryv1.AddressTypeIPv6
+	}
+
+	ports := make([]discoveryv1.EndpointPort, 0, len(service.Spec.Ports))
+	for _, servicePort := range service.Spec.Ports {
+		// named target port could not be resolved without pods, use the service port instead
+		port := servicePort.Port
+		if servicePort.TargetPort.Type == intstr.Int && servicePort.TargetPort.IntVal != 0 {
+			port = servicePort.TargetPort.IntVal
+		}
+		ports = append(ports, discoveryv1.EndpointPort{
+			Name:        lo.ToPtr(servicePort.Name),
+			Protocol:    lo.ToPtr(lo.Ternary(servicePort.Protocol == "", corev1.ProtocolTCP, servicePort.Protocol)),
+			Port:        lo.ToPtr(port),
+			AppProtocol: servicePort.AppProtocol,
+		})
+	}
+
+	endpointSlice := &discoveryv1.EndpointSlice{
+		ObjectMeta: metav1.ObjectMeta{
+			Namespace: service.Namespace,
+			Name:      EndpointSliceName(service.Name),
+			Labels: map[string]string{
+				discoveryv1.LabelServiceName: service.Name,
+				discoveryv1.LabelManagedBy:   EndpointSliceManagedBy,
+			},
+			OwnerReferences: []metav1.OwnerReference{
+				*metav1.NewControllerRef(service, corev1.SchemeGroupVersion.WithKind("Service")),
+			},
+		},
+		AddressType: addressType,
+		Endpoints:   []discoveryv1.Endpoint{},
+		Ports:       ports,
+	}
+	if address != nil {
+		endpointSlice.Endpoints = append(endpointSlice.Endpoints, discoveryv1.Endpoint{
+			Addresses:  []string{address.String()},
+			Conditions: discoveryv1.EndpointConditions{Ready: lo.ToPtr(true)},
+		})
+	}
+	return endpointSlice
+}
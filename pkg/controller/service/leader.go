@@ -0,0 +1,139 @@
+package service
+
+import (
+	"context"
+	"net"
+	"time"
+
+	"github.com/samber/lo"
+	"go.uber.org/atomic"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/client-go/util/workqueue"
+	"k8s.io/klog/v2"
+
+	"github.com/everoute/runtime/pkg/options"
+)
+
+// leaderQueue shares the election notification and the reconcile queue between the service
+// controller and the publisher, the resync key is added when the leading state updated
+type leaderQueue struct {
+	// name is the queue name of the metrics and logs
+	name string
+	// workers is the number of parallel reconcile workers, at least 1
+	workers        int
+	electionClient options.LeaderElectionClient
+	queue          workqueue.RateLimitingInterface
+	resyncKey      interface{}
+	reconcile      func(ctx context.Context, key interface{}) error
+}
+
+// run begins processing items until the ctx done
+func (q *leaderQueue) run(ctx context.Context) {
+	registerMetrics()
+	for i := 0; i < lo.Max([]int{q.workers, 1}); i++ {
+		go wait.UntilWithContext(ctx, q.reconcileWorker, time.Second)
+	}
+	go wait.UntilWithContext(ctx, q.electionNotifier, time.Second)
+
+	// note: quick sync when started
+	// make sure we reconcile even if the first election event lost
+	q.queue.Add(q.resyncKey)
+	<-ctx.Done()
+}
+
+func (q *leaderQueue) reconcileWorker(ctx context.Context) {
+	for {
+		key, quit := q.queue.Get()
+		if quit {
+			return
+		}
+
+		startTime := time.Now()
+		err := q.reconcile(ctx, key)
+		reconcileDuration.WithLabelValues(q.name).Observe(time.Since(startTime).Seconds())
+		reconcileTotal.WithLabelValues(q.name, lo.Ternary(err == nil, "success", "error")).Inc()
+		if err != nil {
+			klog.Errorf("reconcile %s %v: %s", q.name, key, err)
+			q.queue.AddRateLimited(key)
+			q.queue.Done(key)
+			continue
+		}
+
+		// stop the rate limiter from tracking the key
+		q.queue.Done(key)
+		q.queue.Forget(key)
+	}
+}
+
+func (q *leaderQueue) electionNotifier(ctx context.Context) {
+	for q.electionClient.UntilLeadingStateUpdate(ctx.Done()) {
+		q.queue.Add(q.resyncKey)
+	}
+}
+
+// leaderResolver resolves the address which the targets should point to
+type leaderResolver struct {
+	electionClient options.LeaderElectionClient
+	publicIP       net.IP
+	clearOnRelease bool
+	stalenessGuard bool
+
+	// leaderObserved is set when any leader has been observed, so that
+	// an empty leader means the lease released instead of not yet observed
+	leaderObserved atomic.Bool
+}
+
+// active returns whether this node would update any target
+func (r *leaderResolver) active() bool {
+	if r.electionClient.GetLeader() != "" {
+		r.leaderObserved.Store(true)
+	}
+	return r.electionClient.IsLeader() || r.clearOnRelease || r.stalenessGuard
+}
+
+// desiredLocalTarget returns the target of the node-local sinks, which follow the current leader
+// on every replica, empty means the target should be cleared, ok is false if kept unchanged
+func (r *leaderResolver) desiredLocalTarget(own string) (target string, ok bool) {
+	leader := r.electionClient.GetLeader()
+	switch {
+	case r.electionClient.IsLeader():
+		return own, true
+	case leader == "":
+		return "", r.leaderObserved.Load() && r.clearOnRelease
+	default:
+		target = addressOfIdentity(leader)
+		return target, target != ""
+	}
+}
+
+// desiredTarget returns the target which should be published, empty means the target should be
+// cleared, ok is false if the target should be kept unchanged by this node. The own is the target
+// of this node, the leaderTarget resolves the target of the leader, nil if could not be resolved.
+func (r *leaderResolver) desiredTarget(current, own string, leaderTarget func(identity string) string) (target string, ok bool) {
+	leader := r.electionClient.GetLeader()
+	switch {
+	case r.electionClient.IsLeader():
+		return own, true
+	case leader == "":
+		// the lease released, clear targets published by this node, or by any node with staleness guard
+		return "", r.leaderObserved.Load() && r.clearOnRelease && (current == own || r.stalenessGuard)
+	case leaderTarget != nil:
+		target = leaderTarget(leader)
+		return target, r.stalenessGuard && target != ""
+	default:
+		return "", false
+	}
+}
+
+// addressOfIdentity returns the node address in the identity, empty if not found
+func addressOfIdentity(identity string) string {
+	return ipString(options.NodeAddressFromIdentity(identity))
+}
+
+// ipString returns the string of the ip, empty if nil
+func ipString(ip net.IP) string {
+	if ip == nil {
+		return ""
+	}
+	return ip.String()
+}
@@ -0,0 +1,43 @@
+package service
+
+import (
+	"sync"
+
+	"k8s.io/component-base/metrics"
+	"k8s.io/component-base/metrics/legacyregistry"
+	// register the workqueue metrics provider, for queue depth, latency and retries
+	_ "k8s.io/component-base/metrics/prometheus/workqueue"
+)
+
+const metricsSubsystem = "leader_target"
+
+var (
+	reconcileTotal = metrics.NewCounterVec(
+		&metrics.CounterOpts{
+			Subsystem:      metricsSubsystem,
+			Name:           "reconcile_total",
+			Help:           "Number of reconciles of the leader targets, partitioned by the queue and the result.",
+			StabilityLevel: metrics.ALPHA,
+		},
+		[]string{"queue", "result"},
+	)
+	reconcileDuration = metrics.NewHistogramVec(
+		&metrics.HistogramOpts{
+			Subsystem:      metricsSubsystem,
+			Name:           "reconcile_duration_seconds",
+			Help:           "Duration in seconds of reconciles of the leader targets, partitioned by the queue.",
+			Buckets:        metrics.ExponentialBuckets(0.001, 2, 15),
+			StabilityLevel: metrics.ALPHA,
+		},
+		[]string{"queue"},
+	)
+)
+
+var registerMetricsOnce sync.Once
+
+// registerMetrics registers the metrics into the legacy registry, served by the apiserver /metrics
+func registerMetrics() {
+	registerMetricsOnce.Do(func() {
+		legacyregistry.MustRegister(reconcileTotal, reconcileDuration)
+	})
+}
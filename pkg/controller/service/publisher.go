@@ -0,0 +1,178 @@
+package service
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"time"
+
+	"github.com/samber/lo"
+	"k8s.io/apimachinery/pkg/util/runtime"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/client-go/util/workqueue"
+	"k8s.io/klog/v2"
+
+	"github.com/everoute/runtime/pkg/options"
+)
+
+// Sink is a target which the leader address published to
+type Sink interface {
+	// Name identifies the sink, e.g. configmap/namespace/name/key
+	Name() string
+	// Current returns the published target, empty if not published or cleared
+	Current(ctx context.Context) (string, error)
+	// Publish publishes the target, the published target should be cleared if empty
+	Publish(ctx context.Context, target string) error
+}
+
+// NodeLocalSink is a sink local to the node, e.g. a file served by the node local DNS. It's
+// published by every replica with the address of the current leader, while other sinks are
+// published only by the leader, or by any replica with ClearOnRelease or StalenessGuard.
+type NodeLocalSink interface {
+	Sink
+	NodeLocal() bool
+}
+
+// isNodeLocal returns whether the sink should be published by every replica
+func isNodeLocal(sink Sink) bool {
+	nodeLocalSink, ok := sink.(NodeLocalSink)
+	return ok && nodeLocalSink.NodeLocal()
+}
+
+// changedSink decides by itself whether the target should be published, e.g. the EndpointSlice
+// is published when the ports changed even if the target unchanged, otherwise the target is
+// published when different from the current one
+type changedSink interface {
+	changed(current, target string) bool
+}
+
+// targetChanged returns whether the target should be published to the sink
+func targetChanged(sink Sink, current, target string) bool {
+	if changedSink, ok := sink.(changedSink); ok {
+		return changedSink.changed(current, target)
+	}
+	return current != target
+}
+
+// publishSink publishes the desired target to the sink if changed, the desired returns the target
+// resolved from the current one, ok is false if the target should be kept unchanged. The changes
+// are logged instead of published if dryRun.
+func publishSink(ctx context.Context, sink Sink, dryRun bool, desired func(current string) (target string, ok bool, err error)) error {
+	current, err := sink.Current(ctx)
+	if err != nil {
+		return fmt.Errorf("get current target of %s: %w", sink.Name(), err)
+	}
+	target, ok, err := desired(current)
+	if err != nil {
+		return fmt.Errorf("resolve target of %s: %w", sink.Name(), err)
+	}
+	if !ok || !targetChanged(sink, current, target) {
+		return nil
+	}
+	if dryRun {
+		klog.Infof("dry-run: would publish %s to %s", displayTarget(target), sink.Name())
+		return nil
+	}
+
+	klog.Infof("publish %s to %s", displayTarget(target), sink.Name())
+	if err := sink.Publish(ctx, target); err != nil {
+		return fmt.Errorf("publish to %s: %w", sink.Name(), err)
+	}
+	return nil
+}
+
+// displayTarget returns the target string for logs and events, or cleared if empty
+func displayTarget(target string) string {
+	return lo.Ternary(target == "", "cleared", target)
+}
+
+// PublisherConfig decides how the publisher handles the sinks
+type PublisherConfig struct {
+	// ResyncPeriod resyncs all sinks periodically if not zero, in case of the sinks changed by others
+	ResyncPeriod time.Duration
+	// Workers, BaseBackoff, MaxBackoff, QPS and Burst work the same as the Config of the service controller
+	Workers     int
+	BaseBackoff time.Duration
+	MaxBackoff  time.Duration
+	QPS         float64
+	Burst       int
+	// ClearOnRelease and StalenessGuard work the same as the Config of the service controller
+	ClearOnRelease bool
+	StalenessGuard bool
+}
+
+// Publisher publishes the leader address to the sinks, it shares the election notification,
+// the reconcile queue and the release behaviors with the service controller
+type Publisher struct {
+	sinks        []Sink
+	resyncPeriod time.Duration
+	resolver     *leaderResolver
+	leaderQueue  *leaderQueue
+}
+
+// publishAllSinks is the key to resync all sinks
+const publishAllSinks = -1
+
+// NewPublisher creates a publisher of the sinks
+func NewPublisher(electionClient options.LeaderElectionClient, publicIP net.IP, config PublisherConfig, sinks ...Sink) *Publisher {
+	rateLimiter := newRateLimiter(config.BaseBackoff, config.MaxBackoff, config.QPS, config.Burst)
+	p := &Publisher{
+		sinks:        sinks,
+		resyncPeriod: config.ResyncPeriod,
+		resolver: &leaderResolver{
+			electionClient: electionClient,
+			publicIP:       publicIP,
+			clearOnRelease: config.ClearOnRelease,
+			stalenessGuard: config.StalenessGuard,
+		},
+	}
+	p.leaderQueue = &leaderQueue{
+		name:           "leader_address_sink",
+		workers:        config.Workers,
+		electionClient: electionClient,
+		queue:          workqueue.NewNamedRateLimitingQueue(rateLimiter, "leader_address_sink"),
+		resyncKey:      publishAllSinks,
+		reconcile: func(ctx context.Context, key interface{}) error {
+			return p.doReconcile(ctx, key.(int))
+		},
+	}
+	return p
+}
+
+// Run begins processing items until the stopCh closed
+func (p *Publisher) Run(stopCh <-chan struct{}) {
+	defer runtime.HandleCrash()
+	defer p.leaderQueue.queue.ShutDown()
+
+	ctx := wait.ContextForChannel(stopCh)
+	if p.resyncPeriod > 0 {
+		go wait.UntilWithContext(ctx, func(context.Context) { p.leaderQueue.queue.Add(publishAllSinks) }, p.resyncPeriod)
+	}
+	p.leaderQueue.run(ctx)
+}
+
+func (p *Publisher) doReconcile(ctx context.Context, index int) error {
+	active := p.resolver.active()
+
+	if index != publishAllSinks {
+		sink := p.sinks[index]
+		if isNodeLocal(sink) {
+			return publishSink(ctx, sink, false, func(string) (string, bool, error) {
+				target, ok := p.resolver.desiredLocalTarget(p.resolver.publicIP.String())
+				return target, ok, nil
+			})
+		}
+		if !active {
+			return nil // never update when no-longer leading
+		}
+		return publishSink(ctx, sink, false, func(current string) (string, bool, error) {
+			target, ok := p.resolver.desiredTarget(current, p.resolver.publicIP.String(), addressOfIdentity)
+			return target, ok, nil
+		})
+	}
+	// reconcile sinks separately, so that a failed sink never blocks others
+	for i := range p.sinks {
+		p.leaderQueue.queue.Add(i)
+	}
+	return nil
+}
@@ -0,0 +1,119 @@
+package service_test
+
+import (
+	"context"
+	"net"
+	"os"
+	"path/filepath"
+	"time"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	coordinationv1 "k8s.io/api/coordination/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/rand"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/kubernetes/fake"
+
+	"github.com/everoute/runtime/pkg/controller/service"
+	"github.com/everoute/runtime/pkg/options"
+	. "github.com/everoute/runtime/pkg/util/testing"
+)
+
+var _ = Describe("Leader Address Publisher", func() {
+	var ctx context.Context
+	var cancel func()
+	var publisherClientset kubernetes.Interface
+	var hostsPath string
+	var client *FakeLeaderElectionClient
+
+	const namespace, leaseName, configMapName = "default", "leader", "leader-address"
+
+	getConfigMapData := func() map[string]string {
+		configMap, err := publisherClientset.CoreV1().ConfigMaps(namespace).Get(ctx, configMapName, metav1.GetOptions{})
+		Expect(err).ShouldNot(HaveOccurred())
+		return configMap.Data
+	}
+	getLeaseAnnotations := func() map[string]string {
+		lease, err := publisherClientset.CoordinationV1().Leases(namespace).Get(ctx, leaseName, metav1.GetOptions{})
+		Expect(err).ShouldNot(HaveOccurred())
+		return lease.Annotations
+	}
+	getHostsFile := func() string {
+		raw, err := os.ReadFile(hostsPath)
+		if !os.IsNotExist(err) {
+			Expect(err).ShouldNot(HaveOccurred())
+		}
+		return string(raw)
+	}
+
+	BeforeEach(func() {
+		ctx, cancel = context.WithCancel(context.Background())
+		publisherClientset = fake.NewSimpleClientset(
+			&corev1.ConfigMap{ObjectMeta: metav1.ObjectMeta{Namespace: namespace, Name: configMapName}, Data: map[string]string{"other": "kept"}},
+			&coordinationv1.Lease{ObjectMeta: metav1.ObjectMeta{Namespace: namespace, Name: leaseName}},
+		)
+		hostsPath = filepath.Join(GinkgoT().TempDir(), "hosts")
+
+		client = NewFakeLeaderElectionClient("10.1.0.1_" + rand.String(10))
+		client.SetLeader(client.Identity())
+		publisherConfig := service.PublisherConfig{ClearOnRelease: true, ResyncPeriod: time.Second, Workers: 2, BaseBackoff: 10 * time.Millisecond}
+		publisher := service.NewPublisher(client, net.ParseIP("10.1.0.1"), publisherConfig,
+			service.NewConfigMapSink(publisherClientset, namespace, configMapName, "address"),
+			service.NewLeaseAnnotationSink(publisherClientset, namespace, leaseName, "everoute.io/leader-address"),
+			service.NewHostsFileSink(hostsPath, "leader.everoute.local"),
+		)
+		go publisher.Run(ctx.Done())
+	})
+	AfterEach(func() { cancel() })
+
+	It("should publish leader address to all sinks", func() {
+		Eventually(getConfigMapData, testTimeout).Should(And(HaveKeyWithValue("address", "10.1.0.1"), HaveKeyWithValue("other", "kept")))
+		Eventually(getLeaseAnnotations, testTimeout).Should(HaveKeyWithValue("everoute.io/leader-address", "10.1.0.1"))
+		Eventually(getHostsFile, testTimeout).Should(Equal("10.1.0.1 leader.everoute.local\n"))
+	})
+
+	It("should clear leader address when the lease released", func() {
+		Eventually(getHostsFile, testTimeout).ShouldNot(BeEmpty())
+		Eventually(getConfigMapData, testTimeout).Should(HaveKey("address"))
+		Eventually(getLeaseAnnotations, testTimeout).Should(HaveKey("everoute.io/leader-address"))
+
+		Expect(client.Release(ctx)).ShouldNot(HaveOccurred())
+		Eventually(getConfigMapData, testTimeout).ShouldNot(HaveKey("address"))
+		Eventually(getLeaseAnnotations, testTimeout).ShouldNot(HaveKey("everoute.io/leader-address"))
+		Eventually(getHostsFile, testTimeout).Should(BeEmpty())
+	})
+
+	It("should publish leader address to node-local sinks only when following", func() {
+		Eventually(getHostsFile, testTimeout).Should(Equal("10.1.0.1 leader.everoute.local\n"))
+		Eventually(getConfigMapData, testTimeout).Should(HaveKeyWithValue("address", "10.1.0.1"))
+
+		client.SetLeader("10.1.0.2_" + rand.String(10))
+		Eventually(getHostsFile, testTimeout).Should(Equal("10.1.0.2 leader.everoute.local\n"))
+		Consistently(getConfigMapData, 2*time.Second).Should(HaveKeyWithValue("address", "10.1.0.1"))
+		Expect(getLeaseAnnotations()).Should(HaveKeyWithValue("everoute.io/leader-address", "10.1.0.1"))
+	})
+
+	It("should clear leader address when the lease of the election released", func() {
+		electionClient := NewElectionClient(publisherClientset, net.ParseIP("10.1.0.1"), ctx.Done(), "--election-retry-period=100ms")
+		Eventually(electionClient.IsLeader, testTimeout).Should(BeTrue())
+
+		// publish into the sinks different from the publisher with the fake election client
+		hostsPath = filepath.Join(GinkgoT().TempDir(), "hosts")
+		publisher := service.NewPublisher(electionClient, net.ParseIP("10.1.0.1"), service.PublisherConfig{ClearOnRelease: true},
+			service.NewConfigMapSink(publisherClientset, namespace, configMapName, "election-address"),
+			service.NewLeaseAnnotationSink(publisherClientset, namespace, leaseName, "everoute.io/election-address"),
+			service.NewHostsFileSink(hostsPath, "leader.everoute.local"),
+		)
+		go publisher.Run(ctx.Done())
+		Eventually(getConfigMapData, testTimeout).Should(HaveKeyWithValue("election-address", "10.1.0.1"))
+		Eventually(getLeaseAnnotations, testTimeout).Should(HaveKeyWithValue("everoute.io/election-address", "10.1.0.1"))
+		Eventually(getHostsFile, testTimeout).Should(Equal("10.1.0.1 leader.everoute.local\n"))
+
+		Expect(electionClient.(options.Releaser).Release(ctx)).ShouldNot(HaveOccurred())
+		Eventually(getConfigMapData, testTimeout).ShouldNot(HaveKey("election-address"))
+		Eventually(getLeaseAnnotations, testTimeout).ShouldNot(HaveKey("everoute.io/election-address"))
+		Eventually(getHostsFile, testTimeout).Should(BeEmpty())
+	})
+})
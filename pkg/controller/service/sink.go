@@ -0,0 +1,163 @@
+package service
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/kubernetes"
+)
+
+// NewConfigMapSink publishes the leader address into the key of the ConfigMap, the
+// ConfigMap must exist, the key would be removed when the address cleared
+func NewConfigMapSink(clientset kubernetes.Interface, namespace, name, key string) Sink {
+	return &configMapSink{clientset: clientset, namespace: namespace, name: name, key: key}
+}
+
+type configMapSink struct {
+	clientset kubernetes.Interface
+	namespace string
+	name      string
+	key       string
+}
+
+func (s *configMapSink) Name() string {
+	return fmt.Sprintf("configmap/%s/%s/%s", s.namespace, s.name, s.key)
+}
+
+func (s *configMapSink) Current(ctx context.Context) (string, error) {
+	configMap, err := s.clientset.CoreV1().ConfigMaps(s.namespace).Get(ctx, s.name, metav1.GetOptions{})
+	if err != nil {
+		return "", err
+	}
+	return configMap.Data[s.key], nil
+}
+
+func (s *configMapSink) Publish(ctx context.Context, target string) error {
+	patch, err := json.Marshal(map[string]interface{}{
+		"data": map[string]interface{}{s.key: targetOrNull(target)},
+	})
+	if err != nil {
+		return err
+	}
+	_, err = s.clientset.CoreV1().ConfigMaps(s.namespace).Patch(ctx, s.name, types.MergePatchType,
+		patch, metav1.PatchOptions{FieldManager: FieldManager})
+	return err
+}
+
+// NewLeaseAnnotationSink publishes the leader address into the annotation of the Lease,
+// e.g. the lease of the leader election, the annotation would be removed when the address cleared
+func NewLeaseAnnotationSink(clientset kubernetes.Interface, namespace, name, annotation string) Sink {
+	return &leaseAnnotationSink{clientset: clientset, namespace: namespace, name: name, annotation: annotation}
+}
+
+type leaseAnnotationSink struct {
+	clientset  kubernetes.Interface
+	namespace  string
+	name       string
+	annotation string
+}
+
+func (s *leaseAnnotationSink) Name() string {
+	return fmt.Sprintf("lease/%s/%s/%s", s.namespace, s.name, s.annotation)
+}
+
+func (s *leaseAnnotationSink) Current(ctx context.Context) (string, error) {
+	lease, err := s.clientset.CoordinationV1().Leases(s.namespace).Get(ctx, s.name, metav1.GetOptions{})
+	if err != nil {
+		return "", err
+	}
+	return lease.Annotations[s.annotation], nil
+}
+
+func (s *leaseAnnotationSink) Publish(ctx context.Context, target string) error {
+	patch, err := json.Marshal(map[string]interface{}{
+		"metadata": map[string]interface{}{
+			"annotations": map[string]interface{}{s.annotation: targetOrNull(target)},
+		},
+	})
+	if err != nil {
+		return err
+	}
+	_, err = s.clientset.CoordinationV1().Leases(s.namespace).Patch(ctx, s.name, types.MergePatchType,
+		patch, metav1.PatchOptions{FieldManager: FieldManager})
+	return err
+}
+
+// NewHostsFileSink publishes the leader address into a file in hosts format, e.g. served by
+// the CoreDNS hosts plugin. The file contains a single record of the leader address and the
+// hostnames, and would be emptied when the address cleared. It's a node-local sink, published
+// by every replica with the address of the current leader.
+func NewHostsFileSink(path string, hostnames ...string) Sink {
+	return &hostsFileSink{path: path, hostnames: hostnames}
+}
+
+type hostsFileSink struct {
+	path      string
+	hostnames []string
+}
+
+func (s *hostsFileSink) Name() string {
+	return "file/" + s.path
+}
+
+func (s *hostsFileSink) NodeLocal() bool {
+	return true
+}
+
+func (s *hostsFileSink) Current(context.Context) (string, error) {
+	raw, err := os.ReadFile(s.path)
+	if os.IsNotExist(err) {
+		return "", nil
+	}
+	if err != nil {
+		return "", err
+	}
+	fields := strings.Fields(string(raw))
+	if len(fields) != 1+len(s.hostnames) || strings.Join(fields[1:], " ") != strings.Join(s.hostnames, " ") {
+		return "", nil // hostnames changed, the record should be rewritten
+	}
+	return ipString(net.ParseIP(fields[0])), nil
+}
+
+func (s *hostsFileSink) Publish(_ context.Context, target string) error {
+	var content string
+	if target != "" {
+		content = target + " " + strings.Join(s.hostnames, " ") + "\n"
+	}
+
+	// write into a temporary file and rename, so that readers never see a partial file
+	if err := os.MkdirAll(filepath.Dir(s.path), 0755); err != nil {
+		return err
+	}
+	tmpFile, err := os.CreateTemp(filepath.Dir(s.path), filepath.Base(s.path)+".tmp")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmpFile.Name())
+	if _, err = tmpFile.WriteString(content); err != nil {
+		tmpFile.Close()
+		return err
+	}
+	if err = tmpFile.Close(); err != nil {
+		return err
+	}
+	if err = os.Chmod(tmpFile.Name(), 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmpFile.Name(), s.path)
+}
+
+// targetOrNull returns the target, or nil to remove the field by merge patch if empty
+func targetOrNull(target string) interface{} {
+	if target == "" {
+		return nil
+	}
+	return target
+}
@@ -3,9 +3,13 @@ package service_test
 import (
 	"net"
 	"testing"
+	"time"
 
 	. "github.com/onsi/ginkgo/v2"
 	. "github.com/onsi/gomega"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
 	"k8s.io/apimachinery/pkg/types"
 	"k8s.io/apimachinery/pkg/util/rand"
 	"k8s.io/client-go/informers"
@@ -27,6 +31,10 @@ var (
 	serviceNamespaceName01 = types.NamespacedName{Namespace: rand.String(20), Name: rand.String(20)}
 	serviceNamespaceName02 = types.NamespacedName{Namespace: rand.String(20), Name: rand.String(20)}
 	stopCh                 = make(chan struct{})
+
+	selectedNamespace = rand.String(20)
+	selectedLabels    = labels.Set{"everoute.io/unittest": "selected"}
+	nodeName          = rand.String(20)
 )
 
 func TestServiceReconcile(t *testing.T) {
@@ -35,16 +43,26 @@ func TestServiceReconcile(t *testing.T) {
 }
 
 var _ = BeforeSuite(func() {
-	clientset = fake.NewSimpleClientset()
+	clientset = fake.NewSimpleClientset(&corev1.Node{ObjectMeta: metav1.ObjectMeta{
+		Name:        nodeName,
+		Annotations: map[string]string{"everoute.io/storage-ip": "10.2.0.1"},
+	}})
 	f = informers.NewSharedInformerFactory(clientset, 0)
 	name = rand.String(20)
 	electionClient = NewFakeLeaderElectionClient(name)
 	publicIP = net.ParseIP("10.1.0.1")
 
-	serviceController := service.New(clientset, f, electionClient, publicIP, 0,
-		serviceNamespaceName01.String(),
-		serviceNamespaceName02.String(),
-	)
+	serviceController := service.NewWithConfig(clientset, f, electionClient, publicIP, service.Config{
+		IncludeServices:      []string{serviceNamespaceName01.String(), serviceNamespaceName02.String()},
+		LabelSelector:        labels.SelectorFromSet(selectedLabels),
+		Namespaces:           []string{selectedNamespace},
+		EndpointSliceEnabled: true,
+		Workers:              4,
+		BaseBackoff:          10 * time.Millisecond,
+		MaxBackoff:           time.Second,
+		Hostname:             "leader.unittest.local",
+		NodeName:             nodeName,
+	})
 
 	go serviceController.Run(stopCh)
 
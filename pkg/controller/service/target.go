@@ -0,0 +1,117 @@
+package service
+
+import (
+	"fmt"
+	"net"
+	"strings"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/util/validation"
+)
+
+const (
+	// AnnotationLeaderTarget selects the target of the service published by the leader, the
+	// target is resolved by each replica, defaults to the advertise IP. Supported values:
+	//   - advertise-ip: the publicIP of the node
+	//   - hostname: the hostname or FQDN of the node, only for the ExternalName services
+	//   - interface:<name>: the IP of the named network interface on the node
+	//   - node-annotation:<key>: the value of the annotation on the Node object, the services
+	//     are reconciled again when the annotations of the Node changed
+	//
+	// The target only selects the address, the ports of the service are published as is.
+	AnnotationLeaderTarget = "everoute.io/leader-target"
+
+	TargetAdvertiseIP          = "advertise-ip"
+	TargetHostname             = "hostname"
+	TargetInterfacePrefix      = "interface:"
+	TargetNodeAnnotationPrefix = "node-annotation:"
+)
+
+// validateTarget validates the target annotation of the service
+func (c *Controller) validateTarget(service *corev1.Service) error {
+	target, found := service.Annotations[AnnotationLeaderTarget]
+	switch {
+	case !found || target == TargetAdvertiseIP:
+		return nil
+	case target == TargetHostname:
+		if service.Spec.Type != corev1.ServiceTypeExternalName {
+			return fmt.Errorf("target %s only supported by ExternalName services", target)
+		}
+		if c.config.Hostname == "" {
+			return fmt.Errorf("hostname of the node is unknown")
+		}
+		return nil
+	case strings.HasPrefix(target, TargetInterfacePrefix):
+		if strings.TrimPrefix(target, TargetInterfacePrefix) == "" {
+			return fmt.Errorf("interface name must not be empty")
+		}
+		return nil
+	case strings.HasPrefix(target, TargetNodeAnnotationPrefix):
+		if c.config.NodeName == "" {
+			return fmt.Errorf("target %s requires the node name", target)
+		}
+		if errs := validation.IsQualifiedName(strings.TrimPrefix(target, TargetNodeAnnotationPrefix)); len(errs) != 0 {
+			return fmt.Errorf("invalid node annotation key: %s", strings.Join(errs, ", "))
+		}
+		return nil
+	default:
+		return fmt.Errorf("unknown target %q", target)
+	}
+}
+
+// resolveTarget returns the target of this node for the service, override is true if the
+// target selected by the annotation instead of the default advertise IP
+func (c *Controller) resolveTarget(service *corev1.Service) (target string, override bool, err error) {
+	value, found := service.Annotations[AnnotationLeaderTarget]
+	switch {
+	case !found || value == TargetAdvertiseIP:
+		return c.publicIP.String(), false, nil
+	case value == TargetHostname:
+		target = c.config.Hostname
+	case strings.HasPrefix(value, TargetInterfacePrefix):
+		target, err = interfaceAddress(strings.TrimPrefix(value, TargetInterfacePrefix), c.publicIP.To4() != nil)
+	case strings.HasPrefix(value, TargetNodeAnnotationPrefix):
+		var node *corev1.Node
+		node, err = c.nodeLister.Get(c.config.NodeName)
+		if err == nil {
+			target = node.Annotations[strings.TrimPrefix(value, TargetNodeAnnotationPrefix)]
+		}
+	}
+	if err == nil && target == "" {
+		err = fmt.Errorf("target %s resolved empty", value)
+	}
+	if err == nil && service.Spec.Type != corev1.ServiceTypeExternalName && net.ParseIP(target) == nil {
+		err = fmt.Errorf("target %s resolved %q is not an IP", value, target)
+	}
+	return target, true, err
+}
+
+// interfaceAddress returns the global unicast IP of the interface, the IPv4 is preferred if ipv4
+func interfaceAddress(name string, ipv4 bool) (string, error) {
+	iface, err := net.InterfaceByName(name)
+	if err != nil {
+		return "", err
+	}
+	addrs, err := iface.Addrs()
+	if err != nil {
+		return "", err
+	}
+
+	var candidate net.IP
+	for _, addr := range addrs {
+		ipNet, ok := addr.(*net.IPNet)
+		if !ok || !ipNet.IP.IsGlobalUnicast() {
+			continue
+		}
+		if (ipNet.IP.To4() != nil) == ipv4 {
+			return ipNet.IP.String(), nil
+		}
+		if candidate == nil {
+			candidate = ipNet.IP
+		}
+	}
+	if candidate == nil {
+		return "", fmt.Errorf("no global unicast address on interface %s", name)
+	}
+	return candidate.String(), nil
+}
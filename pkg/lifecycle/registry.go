@@ -0,0 +1,242 @@
+package lifecycle
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+
+	utilerrors "k8s.io/apimachinery/pkg/util/errors"
+	"k8s.io/apimachinery/pkg/util/sets"
+	"k8s.io/klog/v2"
+)
+
+// Phase is the lifecycle phase of the apiserver
+type Phase string
+
+const (
+	// PhasePostStart runs after the apiserver started
+	PhasePostStart Phase = "post-start"
+	// PhaseStartedLeading runs when the current node starts leading
+	PhaseStartedLeading Phase = "started-leading"
+	// PhaseStoppedLeading runs when the current node stops leading
+	PhaseStoppedLeading Phase = "stopped-leading"
+	// PhasePreShutdown runs before the apiserver stops serving
+	PhasePreShutdown Phase = "pre-shutdown"
+	// PhaseShutdown runs after the apiserver stopped serving
+	PhaseShutdown Phase = "shutdown"
+)
+
+// FailurePolicy decides how to handle the hook failure
+type FailurePolicy string
+
+const (
+	// FailurePolicyIgnore logs the failure and continues
+	FailurePolicyIgnore FailurePolicy = "Ignore"
+	// FailurePolicyFail reports the failure through health checks and fails the phase
+	FailurePolicyFail FailurePolicy = "Fail"
+)
+
+// HookFunc is the function called in the phase
+type HookFunc func(ctx context.Context) error
+
+// Hook is a named function called in the phase
+type Hook struct {
+	Name  string
+	Phase Phase
+	Func  HookFunc
+	// DependsOn is the hooks in the same phase must be called before this hook,
+	// the hook would be skipped if any of them failed
+	DependsOn []string
+	// Timeout is the max duration of the hook, zero means never timeout
+	Timeout time.Duration
+	// FailurePolicy defaults to Fail
+	FailurePolicy FailurePolicy
+}
+
+// Registry registers hooks and runs them by phase
+type Registry struct {
+	lock     sync.RWMutex
+	hooks    map[Phase][]Hook
+	failures map[string]error
+}
+
+// NewRegistry creates a new instance of lifecycle hooks registry
+func NewRegistry() *Registry {
+	return &Registry{
+		hooks:    make(map[Phase][]Hook),
+		failures: make(map[string]error),
+	}
+}
+
+// Register adds the hook into registry, the hook name must be unique in the phase
+func (r *Registry) Register(hook Hook) error {
+	if hook.Name == "" {
+		return fmt.Errorf("missing name")
+	}
+	if hook.Func == nil {
+		return fmt.Errorf("hook func may not be nil: %q", hook.Name)
+	}
+	switch hook.FailurePolicy {
+	case "":
+		hook.FailurePolicy = FailurePolicyFail
+	case FailurePolicyIgnore, FailurePolicyFail:
+	default:
+		return fmt.Errorf("invalid failure policy %q of hook %q", hook.FailurePolicy, hook.Name)
+	}
+
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	for _, h := range r.hooks[hook.Phase] {
+		if h.Name == hook.Name {
+			return fmt.Errorf("unable to add %q because it was already registered in phase %s", hook.Name, hook.Phase)
+		}
+	}
+	r.hooks[hook.Phase] = append(r.hooks[hook.Phase], hook)
+	return nil
+}
+
+// Validate checks the dependencies of hooks in all phases
+func (r *Registry) Validate() error {
+	r.lock.RLock()
+	defer r.lock.RUnlock()
+
+	var errs []error
+	for phase, hooks := range r.hooks {
+		if _, err := sortHooks(hooks); err != nil {
+			errs = append(errs, fmt.Errorf("phase %s: %w", phase, err))
+		}
+	}
+	return utilerrors.NewAggregate(errs)
+}
+
+// Run calls the hooks in the phase by dependency order, returns error if any hook with
+// Fail policy failed. It's safe to call on nil registry.
+func (r *Registry) Run(ctx context.Context, phase Phase) error {
+	if r == nil {
+		return nil
+	}
+
+	r.lock.RLock()
+	hooks, err := sortHooks(r.hooks[phase])
+	r.lock.RUnlock()
+	if err != nil {
+		return fmt.Errorf("phase %s: %w", phase, err)
+	}
+
+	var errs []error
+	failed := sets.New[string]()
+	for _, hook := range hooks {
+		if failedDeps := failed.Intersection(sets.New(hook.DependsOn...)); failedDeps.Len() != 0 {
+			err = fmt.Errorf("skipped because dependencies %v failed", sets.List(failedDeps))
+		} else {
+			err = runHook(ctx, hook)
+		}
+		r.setFailure(hook, err)
+
+		if err != nil {
+			failed.Insert(hook.Name)
+			klog.Errorf("lifecycle hook %s in phase %s: %s", hook.Name, phase, err)
+			if hook.FailurePolicy == FailurePolicyFail {
+				errs = append(errs, fmt.Errorf("hook %s: %w", hook.Name, err))
+			}
+		}
+	}
+	return utilerrors.NewAggregate(errs)
+}
+
+// Name implements healthz.HealthChecker
+func (r *Registry) Name() string { return "lifecycle-hooks" }
+
+// Check implements healthz.HealthChecker, returns error if any hook with Fail policy failed
+func (r *Registry) Check(*http.Request) error {
+	r.lock.RLock()
+	defer r.lock.RUnlock()
+
+	names := make([]string, 0, len(r.failures))
+	for name := range r.failures {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	errs := make([]error, 0, len(names))
+	for _, name := range names {
+		errs = append(errs, fmt.Errorf("hook %s: %w", name, r.failures[name]))
+	}
+	return utilerrors.NewAggregate(errs)
+}
+
+func (r *Registry) setFailure(hook Hook, err error) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	key := string(hook.Phase) + "/" + hook.Name
+	if err == nil || hook.FailurePolicy != FailurePolicyFail {
+		delete(r.failures, key)
+		return
+	}
+	r.failures[key] = err
+}
+
+func runHook(ctx context.Context, hook Hook) (err error) {
+	if hook.Timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, hook.Timeout)
+		defer cancel()
+	}
+
+	start := time.Now()
+	errCh := make(chan error, 1)
+	go func() {
+		defer func() {
+			if r := recover(); r != nil {
+				errCh <- fmt.Errorf("panic: %v", r)
+			}
+		}()
+		errCh <- hook.Func(ctx)
+	}()
+
+	select {
+	case err = <-errCh:
+	case <-ctx.Done():
+		err = fmt.Errorf("timeout after %s: %w", time.Since(start).Round(time.Millisecond), ctx.Err())
+	}
+	klog.V(4).Infof("lifecycle hook %s in phase %s finished in %s", hook.Name, hook.Phase, time.Since(start))
+	return err
+}
+
+// sortHooks returns the hooks in dependency order, hooks without dependency
+// relationship keep the registered order
+func sortHooks(hooks []Hook) ([]Hook, error) {
+	index := make(map[string]int, len(hooks))
+	for i, hook := range hooks {
+		index[hook.Name] = i
+	}
+	for _, hook := range hooks {
+		for _, dep := range hook.DependsOn {
+			if _, ok := index[dep]; !ok {
+				return nil, fmt.Errorf("hook %s depends on unknown hook %s", hook.Name, dep)
+			}
+		}
+	}
+
+	sorted := make([]Hook, 0, len(hooks))
+	visited := sets.New[string]()
+	for len(sorted) < len(hooks) {
+		progress := false
+		for _, hook := range hooks {
+			if !visited.Has(hook.Name) && visited.HasAll(hook.DependsOn...) {
+				visited.Insert(hook.Name)
+				sorted = append(sorted, hook)
+				progress = true
+			}
+		}
+		if !progress {
+			return nil, fmt.Errorf("circular dependencies among hooks %v", sets.List(sets.KeySet(index).Difference(visited)))
+		}
+	}
+	return sorted, nil
+}
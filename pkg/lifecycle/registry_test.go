@@ -0,0 +1,124 @@
+package lifecycle_test
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	. "github.com/onsi/gomega"
+
+	"github.com/everoute/runtime/pkg/lifecycle"
+)
+
+func TestRegistryRun(t *testing.T) {
+	RegisterTestingT(t)
+
+	var called []string
+	newHook := func(name string, err error, dependsOn ...string) lifecycle.Hook {
+		return lifecycle.Hook{
+			Name:      name,
+			Phase:     lifecycle.PhasePostStart,
+			DependsOn: dependsOn,
+			Func:      func(context.Context) error { called = append(called, name); return err },
+		}
+	}
+
+	t.Run("should call hooks by dependency order", func(t *testing.T) {
+		called = nil
+		r := lifecycle.NewRegistry()
+		Expect(r.Register(newHook("c", nil, "b"))).ShouldNot(HaveOccurred())
+		Expect(r.Register(newHook("b", nil, "a"))).ShouldNot(HaveOccurred())
+		Expect(r.Register(newHook("a", nil))).ShouldNot(HaveOccurred())
+		Expect(r.Validate()).ShouldNot(HaveOccurred())
+
+		Expect(r.Run(context.Background(), lifecycle.PhasePostStart)).ShouldNot(HaveOccurred())
+		Expect(called).Should(Equal([]string{"a", "b", "c"}))
+		Expect(r.Run(context.Background(), lifecycle.PhaseShutdown)).ShouldNot(HaveOccurred())
+		Expect(called).Should(HaveLen(3))
+	})
+
+	t.Run("should skip hooks when dependencies failed", func(t *testing.T) {
+		called = nil
+		r := lifecycle.NewRegistry()
+		Expect(r.Register(newHook("a", fmt.Errorf("unexpected error")))).ShouldNot(HaveOccurred())
+		Expect(r.Register(newHook("b", nil, "a"))).ShouldNot(HaveOccurred())
+		Expect(r.Register(newHook("c", nil))).ShouldNot(HaveOccurred())
+
+		err := r.Run(context.Background(), lifecycle.PhasePostStart)
+		Expect(err).Should(MatchError(ContainSubstring("unexpected error")))
+		Expect(err).Should(MatchError(ContainSubstring("skipped because dependencies [a] failed")))
+		Expect(called).Should(Equal([]string{"a", "c"}))
+		Expect(r.Check(nil)).Should(HaveOccurred())
+	})
+
+	t.Run("should ignore failure with ignore policy", func(t *testing.T) {
+		r := lifecycle.NewRegistry()
+		hook := newHook("a", fmt.Errorf("unexpected error"))
+		hook.FailurePolicy = lifecycle.FailurePolicyIgnore
+		Expect(r.Register(hook)).ShouldNot(HaveOccurred())
+
+		Expect(r.Run(context.Background(), lifecycle.PhasePostStart)).ShouldNot(HaveOccurred())
+		Expect(r.Check(nil)).ShouldNot(HaveOccurred())
+	})
+
+	t.Run("should fail hooks when timeout or panic", func(t *testing.T) {
+		r := lifecycle.NewRegistry()
+		Expect(r.Register(lifecycle.Hook{
+			Name:    "timeout",
+			Phase:   lifecycle.PhasePreShutdown,
+			Timeout: 100 * time.Millisecond,
+			Func:    func(ctx context.Context) error { <-ctx.Done(); time.Sleep(time.Second); return nil },
+		})).ShouldNot(HaveOccurred())
+		Expect(r.Register(lifecycle.Hook{
+			Name:  "panic",
+			Phase: lifecycle.PhasePreShutdown,
+			Func:  func(context.Context) error { panic("unexpected panic") },
+		})).ShouldNot(HaveOccurred())
+
+		err := r.Run(context.Background(), lifecycle.PhasePreShutdown)
+		Expect(err).Should(MatchError(ContainSubstring("timeout after")))
+		Expect(err).Should(MatchError(ContainSubstring("unexpected panic")))
+	})
+
+	t.Run("should recover health when hook succeeded again", func(t *testing.T) {
+		var err error
+		r := lifecycle.NewRegistry()
+		Expect(r.Register(lifecycle.Hook{
+			Name:  "a",
+			Phase: lifecycle.PhaseStartedLeading,
+			Func:  func(context.Context) error { return err },
+		})).ShouldNot(HaveOccurred())
+
+		err = fmt.Errorf("unexpected error")
+		Expect(r.Run(context.Background(), lifecycle.PhaseStartedLeading)).Should(HaveOccurred())
+		Expect(r.Check(nil)).Should(MatchError(ContainSubstring("started-leading/a")))
+		err = nil
+		Expect(r.Run(context.Background(), lifecycle.PhaseStartedLeading)).ShouldNot(HaveOccurred())
+		Expect(r.Check(nil)).ShouldNot(HaveOccurred())
+	})
+
+	t.Run("should run nil registry", func(t *testing.T) {
+		var r *lifecycle.Registry
+		Expect(r.Run(context.Background(), lifecycle.PhaseShutdown)).ShouldNot(HaveOccurred())
+	})
+}
+
+func TestRegistryValidate(t *testing.T) {
+	RegisterTestingT(t)
+
+	noop := func(context.Context) error { return nil }
+
+	r := lifecycle.NewRegistry()
+	Expect(r.Register(lifecycle.Hook{Phase: lifecycle.PhasePostStart, Func: noop})).Should(HaveOccurred())
+	Expect(r.Register(lifecycle.Hook{Name: "a", Phase: lifecycle.PhasePostStart})).Should(HaveOccurred())
+	Expect(r.Register(lifecycle.Hook{Name: "a", Phase: lifecycle.PhasePostStart, Func: noop, FailurePolicy: "unknown"})).Should(HaveOccurred())
+
+	Expect(r.Register(lifecycle.Hook{Name: "a", Phase: lifecycle.PhasePostStart, Func: noop, DependsOn: []string{"b"}})).ShouldNot(HaveOccurred())
+	Expect(r.Register(lifecycle.Hook{Name: "a", Phase: lifecycle.PhasePostStart, Func: noop})).Should(HaveOccurred())
+	Expect(r.Validate()).Should(MatchError(ContainSubstring("unknown hook b")))
+
+	Expect(r.Register(lifecycle.Hook{Name: "b", Phase: lifecycle.PhasePostStart, Func: noop, DependsOn: []string{"a"}})).ShouldNot(HaveOccurred())
+	Expect(r.Validate()).Should(MatchError(ContainSubstring("circular dependencies")))
+	Expect(r.Run(context.Background(), lifecycle.PhasePostStart)).Should(HaveOccurred())
+}
@@ -0,0 +1,102 @@
+package options
+
+import (
+	"fmt"
+
+	"k8s.io/apiserver/pkg/admission"
+	"k8s.io/apiserver/pkg/admission/plugin/namespace/lifecycle"
+	genericoptions "k8s.io/apiserver/pkg/server/options"
+	utilfeature "k8s.io/apiserver/pkg/util/feature"
+)
+
+// AdmissionPlugin is an in-process admission plugin registered by the consumer
+type AdmissionPlugin struct {
+	Name    string
+	Factory admission.Factory
+	// DefaultOff disables the plugin unless enabled by --enable-admission-plugins
+	DefaultOff bool
+}
+
+// WantsRecommendedConfig is implemented by admission plugins which need the config,
+// e.g. the Clientset or the LeaderElectionClient
+type WantsRecommendedConfig interface {
+	SetRecommendedConfig(config *RecommendedConfig)
+	admission.InitializationValidator
+}
+
+// NewAdmissionOptions returns options of the admission chain, which contains the NamespaceLifecycle,
+// the in-process plugins and the mutating and validating webhooks. Namespaces, webhook configurations
+// are read from the core cluster by the Clientset and the SharedInformerFactory, so the core api
+// options must be applied before. The built-in plugins are enabled by default, they could be
+// disabled by the --disable-admission-plugins. The plugins with invalid names are not registered,
+// they are reported by Validate.
+func NewAdmissionOptions(plugins ...AdmissionPlugin) Options {
+	opts := genericoptions.NewAdmissionOptions()
+
+	// in-process plugins run after the NamespaceLifecycle, and before the webhooks
+	var names []string
+	var errs []error
+	for _, plugin := range plugins {
+		if err := validatePluginName(opts.Plugins, plugin.Name); err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		opts.Plugins.Register(plugin.Name, plugin.Factory)
+		names = append(names, plugin.Name)
+		if plugin.DefaultOff {
+			opts.DefaultOffPlugins.Insert(plugin.Name)
+		}
+	}
+	order := []string{lifecycle.PluginName}
+	order = append(order, names...)
+	for _, name := range opts.RecommendedPluginOrder {
+		if name != lifecycle.PluginName {
+			order = append(order, name)
+		}
+	}
+	opts.RecommendedPluginOrder = order
+
+	return &admissionOptions{AdmissionOptions: opts, errs: errs}
+}
+
+// validatePluginName returns error if the name is empty or registered, e.g. the built-in plugins
+func validatePluginName(registered *admission.Plugins, name string) error {
+	if name == "" {
+		return fmt.Errorf("admission plugin name must not be empty")
+	}
+	for _, registeredName := range registered.Registered() {
+		if registeredName == name {
+			return fmt.Errorf("admission plugin %q registered more than once", name)
+		}
+	}
+	return nil
+}
+
+type admissionOptions struct {
+	*genericoptions.AdmissionOptions
+	// errs are the invalid plugins found when registered
+	errs []error
+}
+
+func (o *admissionOptions) Validate() []error {
+	return append(o.AdmissionOptions.Validate(), o.errs...)
+}
+
+func (o *admissionOptions) ApplyTo(config *RecommendedConfig) error {
+	if config.SharedInformerFactory == nil || config.ClientConfig == nil {
+		return fmt.Errorf("admission depends on the core api options, it must be applied before")
+	}
+	return o.AdmissionOptions.ApplyTo(&config.Config, config.SharedInformerFactory, config.ClientConfig,
+		utilfeature.DefaultFeatureGate, recommendedConfigInitializer{config: config})
+}
+
+// recommendedConfigInitializer sets the config into plugins implement WantsRecommendedConfig
+type recommendedConfigInitializer struct {
+	config *RecommendedConfig
+}
+
+func (i recommendedConfigInitializer) Initialize(plugin admission.Interface) {
+	if wants, ok := plugin.(WantsRecommendedConfig); ok {
+		wants.SetRecommendedConfig(i.config)
+	}
+}
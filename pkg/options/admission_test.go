@@ -0,0 +1,174 @@
+package options_test
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+
+	. "github.com/onsi/gomega"
+	"github.com/spf13/pflag"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apiserver/pkg/admission"
+	"k8s.io/apiserver/pkg/authentication/user"
+	"k8s.io/client-go/informers"
+	"k8s.io/client-go/kubernetes/fake"
+	"k8s.io/client-go/kubernetes/scheme"
+	"k8s.io/client-go/rest"
+
+	"github.com/everoute/runtime/pkg/options"
+)
+
+// denyNamePlugin rejects creation of pods with the name
+type denyNamePlugin struct {
+	*admission.Handler
+	name   string
+	config *options.RecommendedConfig
+}
+
+func (p *denyNamePlugin) SetRecommendedConfig(config *options.RecommendedConfig) { p.config = config }
+
+func (p *denyNamePlugin) ValidateInitialization() error {
+	if p.config == nil {
+		return fmt.Errorf("missing config")
+	}
+	return nil
+}
+
+func (p *denyNamePlugin) Validate(_ context.Context, a admission.Attributes, _ admission.ObjectInterfaces) error {
+	if a.GetName() == p.name {
+		return admission.NewForbidden(a, fmt.Errorf("name %s is denied", p.name))
+	}
+	return nil
+}
+
+func TestAdmissionOptions(t *testing.T) {
+	RegisterTestingT(t)
+
+	plugin := options.AdmissionPlugin{
+		Name: "DenyName",
+		Factory: func(io.Reader) (admission.Interface, error) {
+			return &denyNamePlugin{Handler: admission.NewHandler(admission.Create), name: "denied"}, nil
+		},
+	}
+	newConfig := func() *options.RecommendedConfig {
+		config := options.NewRecommendedConfig(scheme.Codecs)
+		config.ClientConfig = &rest.Config{Host: "https://127.0.0.1:6443"}
+		config.Clientset = fake.NewSimpleClientset()
+		config.SharedInformerFactory = informers.NewSharedInformerFactory(config.Clientset, 0)
+		return config
+	}
+	newAttributes := func(name string) admission.Attributes {
+		return admission.NewAttributesRecord(&corev1.Pod{ObjectMeta: metav1.ObjectMeta{Name: name}}, nil,
+			corev1.SchemeGroupVersion.WithKind("Pod"), "default", name, corev1.SchemeGroupVersion.WithResource("pods"),
+			"", admission.Create, &metav1.CreateOptions{}, false, &user.DefaultInfo{Name: "unittest"})
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	t.Run("should run in-process plugins", func(t *testing.T) {
+		opts := options.NewAdmissionOptions(plugin)
+		flagSet := pflag.NewFlagSet("unittest", pflag.ContinueOnError)
+		opts.AddFlags(flagSet)
+		Expect(flagSet.Parse([]string{"--disable-admission-plugins=NamespaceLifecycle"})).ShouldNot(HaveOccurred())
+		Expect(opts.Validate()).Should(BeEmpty())
+
+		config := newConfig()
+		Expect(opts.ApplyTo(config)).ShouldNot(HaveOccurred())
+		config.SharedInformerFactory.Start(ctx.Done())
+		config.SharedInformerFactory.WaitForCacheSync(ctx.Done())
+		validator, ok := config.AdmissionControl.(admission.ValidationInterface)
+		Expect(ok).Should(BeTrue())
+		Expect(validator.Validate(context.Background(), newAttributes("allowed"), nil)).ShouldNot(HaveOccurred())
+		Expect(validator.Validate(context.Background(), newAttributes("denied"), nil)).Should(MatchError(ContainSubstring("name denied is denied")))
+	})
+
+	t.Run("should not run disabled plugins", func(t *testing.T) {
+		plugin := plugin
+		plugin.DefaultOff = true
+		opts := options.NewAdmissionOptions(plugin)
+		flagSet := pflag.NewFlagSet("unittest", pflag.ContinueOnError)
+		opts.AddFlags(flagSet)
+		Expect(flagSet.Parse([]string{"--disable-admission-plugins=NamespaceLifecycle"})).ShouldNot(HaveOccurred())
+
+		config := newConfig()
+		Expect(opts.ApplyTo(config)).ShouldNot(HaveOccurred())
+		config.SharedInformerFactory.Start(ctx.Done())
+		config.SharedInformerFactory.WaitForCacheSync(ctx.Done())
+		validator := config.AdmissionControl.(admission.ValidationInterface)
+		Expect(validator.Validate(context.Background(), newAttributes("denied"), nil)).ShouldNot(HaveOccurred())
+	})
+
+	t.Run("should reject unknown plugins", func(t *testing.T) {
+		opts := options.NewAdmissionOptions(plugin)
+		flagSet := pflag.NewFlagSet("unittest", pflag.ContinueOnError)
+		opts.AddFlags(flagSet)
+		Expect(flagSet.Parse([]string{"--enable-admission-plugins=Unknown"})).ShouldNot(HaveOccurred())
+		Expect(opts.Validate()).ShouldNot(BeEmpty())
+	})
+
+	t.Run("should reject plugins with invalid names", func(t *testing.T) {
+		for _, name := range []string{"", plugin.Name, "NamespaceLifecycle", "ValidatingAdmissionWebhook"} {
+			invalid := plugin
+			invalid.Name = name
+			opts := options.NewAdmissionOptions(plugin, invalid)
+			Expect(opts.Validate()).Should(HaveLen(1), "plugin name %q", name)
+		}
+	})
+
+	t.Run("should enable webhooks by default from the admission config file", func(t *testing.T) {
+		dir := t.TempDir()
+		kubeconfig := filepath.Join(dir, "kubeconfig")
+		Expect(os.WriteFile(kubeconfig, []byte(webhookKubeconfig), 0600)).ShouldNot(HaveOccurred())
+		admissionConfig := filepath.Join(dir, "admission.yaml")
+		Expect(os.WriteFile(admissionConfig, []byte(fmt.Sprintf(webhookAdmissionConfig, kubeconfig, kubeconfig)), 0600)).ShouldNot(HaveOccurred())
+
+		opts := options.NewAdmissionOptions(plugin)
+		flagSet := pflag.NewFlagSet("unittest", pflag.ContinueOnError)
+		opts.AddFlags(flagSet)
+		Expect(flagSet.Parse([]string{"--admission-control-config-file=" + admissionConfig})).ShouldNot(HaveOccurred())
+		Expect(opts.Validate()).Should(BeEmpty())
+
+		config := newConfig()
+		Expect(opts.ApplyTo(config)).ShouldNot(HaveOccurred())
+		_, ok := config.AdmissionControl.(admission.MutationInterface)
+		Expect(ok).Should(BeTrue())
+
+		Expect(os.Remove(kubeconfig)).ShouldNot(HaveOccurred())
+		Expect(opts.ApplyTo(newConfig())).Should(MatchError(ContainSubstring(kubeconfig)))
+	})
+
+	t.Run("should require core api options", func(t *testing.T) {
+		opts := options.NewAdmissionOptions(plugin)
+		Expect(opts.ApplyTo(options.NewRecommendedConfig(scheme.Codecs))).Should(HaveOccurred())
+	})
+}
+
+const webhookKubeconfig = `
+apiVersion: v1
+kind: Config
+users:
+- name: "*"
+  user:
+    token: unittest
+`
+
+const webhookAdmissionConfig = `
+apiVersion: apiserver.config.k8s.io/v1
+kind: AdmissionConfiguration
+plugins:
+- name: MutatingAdmissionWebhook
+  configuration:
+    apiVersion: apiserver.config.k8s.io/v1
+    kind: WebhookAdmissionConfiguration
+    kubeConfigFile: %s
+- name: ValidatingAdmissionWebhook
+  configuration:
+    apiVersion: apiserver.config.k8s.io/v1
+    kind: WebhookAdmissionConfiguration
+    kubeConfigFile: %s
+`
@@ -2,17 +2,27 @@ package options
 
 import (
 	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
 	"sync"
 	"time"
 
 	"github.com/google/uuid"
 	"github.com/samber/lo"
 	"github.com/spf13/pflag"
+	"go.uber.org/atomic"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/util/wait"
 	genericapiserver "k8s.io/apiserver/pkg/server"
+	"k8s.io/apiserver/pkg/server/healthz"
 	"k8s.io/client-go/tools/leaderelection"
 	"k8s.io/client-go/tools/leaderelection/resourcelock"
+	"k8s.io/klog/v2"
 )
 
 // LeaderElectionClient show election state
@@ -23,33 +33,107 @@ type LeaderElectionClient interface {
 	UntilLeadingStateUpdate(stopCh <-chan struct{}) bool
 }
 
+// Releaser is optionally implemented by the LeaderElectionClient to give up the lease on shutdown
+type Releaser interface {
+	// Release stops the election and gives up the lease if leading,
+	// blocks until the lease released or the context done.
+	Release(ctx context.Context) error
+}
+
+// LeaderLostPolicy is the policy when another node becomes leader
+type LeaderLostPolicy string
+
+const (
+	// LeaderLostPolicyExit shutdown the apiserver and exit the process
+	LeaderLostPolicyExit LeaderLostPolicy = "exit"
+	// LeaderLostPolicyDemote keeps serving as a follower and no longer participates in election
+	LeaderLostPolicyDemote LeaderLostPolicy = "demote"
+	// LeaderLostPolicyRestartControllers keeps serving and participating in election, the
+	// started-leading lifecycle hooks would be called again when leading again
+	LeaderLostPolicyRestartControllers LeaderLostPolicy = "restart-controllers"
+)
+
 func NewElectionOptions() Options {
 	return &electionOptions{}
 }
 
 type electionOptions struct {
-	Enabled       bool
-	NodeIdentity  string
-	Name          string
-	Namespace     string
-	LeaseDuration time.Duration
-	RenewDeadline time.Duration
-	RetryPeriod   time.Duration
-	LeaseTimeout  time.Duration
+	Enabled         bool
+	NodeIdentity    string
+	IdentityFile    string
+	IdentityEnvs    []string
+	FastTakeover    bool
+	LeaderReadyz    bool
+	ReadyzPath      string
+	LostPolicy      string
+	HandoverTimeout time.Duration
+	Name            string
+	Namespace       string
+	LeaseDuration   time.Duration
+	RenewDeadline   time.Duration
+	RetryPeriod     time.Duration
+	LeaseTimeout    time.Duration
 }
 
 func (o *electionOptions) AddFlags(flagSet *pflag.FlagSet) {
 	flagSet.BoolVar(&o.Enabled, "election-enabled", false, "whether enable leader election")
 	flagSet.StringVar(&o.NodeIdentity, "election-identity", "", "leader election node identity")
+	flagSet.StringVar(&o.IdentityFile, "election-identity-file", "", "file to persist the node identity across restarts")
+	flagSet.StringSliceVar(&o.IdentityEnvs, "election-identity-envs", nil, "environments used as node identity, e.g. POD_NAME from downward API")
+	flagSet.BoolVar(&o.FastTakeover, "election-fast-takeover", false, "take over the lease immediately when it held by the same node before restart")
 	flagSet.StringVar(&o.Name, "election-name", "", "leader election lease name to use")
 	flagSet.StringVar(&o.Namespace, "election-namespace", "kube-system", "leader election lease namespace to use")
+	flagSet.BoolVar(&o.LeaderReadyz, "election-leader-readyz", false, "report not-ready on /readyz when the node is not leading")
+	flagSet.StringVar(&o.ReadyzPath, "election-leader-readyz-path", "", "path to serve leader-only readiness separately, e.g. /readyz-leader")
+	flagSet.StringVar(&o.LostPolicy, "election-lost-policy", string(LeaderLostPolicyExit), "policy when another node becomes leader, one of: exit, demote, restart-controllers")
+	flagSet.DurationVar(&o.HandoverTimeout, "election-handover-timeout", 30*time.Second, "max duration to wait for the new leader ready before shutdown, 0 means never wait")
 	flagSet.DurationVar(&o.LeaseDuration, "election-lease-duration", 15*time.Second, "duration that non-leader candidates will wait to acquire leadership")
 	flagSet.DurationVar(&o.RenewDeadline, "election-renew-deadline", 10*time.Second, "duration that the master refreshing leadership before giving up")
 	flagSet.DurationVar(&o.RetryPeriod, "election-retry-period", 2*time.Second, "duration that the clients should wait between tries of actions")
 	flagSet.DurationVar(&o.LeaseTimeout, "election-lease-timeout", 20*time.Second, "timeout of the lease expiry to be allowed")
 }
 
-func (o *electionOptions) Validate() []error { return nil }
+func (o *electionOptions) Validate() []error {
+	if !o.Enabled {
+		return nil
+	}
+
+	var errs []error
+	if o.Name == "" {
+		errs = append(errs, fmt.Errorf("election name must be specified when election enabled"))
+	}
+	if o.Namespace == "" {
+		errs = append(errs, fmt.Errorf("election namespace must be specified when election enabled"))
+	}
+	if lo.Count([]bool{o.NodeIdentity != "", o.IdentityFile != "", len(o.IdentityEnvs) != 0}, true) > 1 {
+		errs = append(errs, fmt.Errorf("only one of election identity, identity file and identity envs could be specified"))
+	}
+	if o.RetryPeriod <= 0 {
+		errs = append(errs, fmt.Errorf("election retry period %s must be greater than zero", o.RetryPeriod))
+	}
+	if o.RenewDeadline <= time.Duration(leaderelection.JitterFactor*float64(o.RetryPeriod)) {
+		errs = append(errs, fmt.Errorf("election renew deadline %s must be greater than retry period %s * %.1f",
+			o.RenewDeadline, o.RetryPeriod, leaderelection.JitterFactor))
+	}
+	if o.LeaseDuration <= o.RenewDeadline {
+		errs = append(errs, fmt.Errorf("election lease duration %s must be greater than renew deadline %s", o.LeaseDuration, o.RenewDeadline))
+	}
+	if o.ReadyzPath != "" && (!strings.HasPrefix(o.ReadyzPath, "/") || o.ReadyzPath == "/") {
+		errs = append(errs, fmt.Errorf("election leader readyz path %s must be an absolute non-root path", o.ReadyzPath))
+	}
+	switch LeaderLostPolicy(o.LostPolicy) {
+	case LeaderLostPolicyExit, LeaderLostPolicyDemote, LeaderLostPolicyRestartControllers:
+	default:
+		errs = append(errs, fmt.Errorf("invalid election lost policy %q", o.LostPolicy))
+	}
+	if o.HandoverTimeout < 0 {
+		errs = append(errs, fmt.Errorf("election handover timeout %s must not be negative", o.HandoverTimeout))
+	}
+	if o.LeaseTimeout < o.LeaseDuration {
+		errs = append(errs, fmt.Errorf("election lease timeout %s must not be less than lease duration %s", o.LeaseTimeout, o.LeaseDuration))
+	}
+	return errs
+}
 
 func (o *electionOptions) ApplyTo(config *RecommendedConfig) error {
 	if !o.Enabled {
@@ -63,7 +147,11 @@ func (o *electionOptions) ApplyTo(config *RecommendedConfig) error {
 		config.LeaderCallbacks.OnStoppedLeading = func() {}
 	}
 	if o.NodeIdentity == "" {
-		o.NodeIdentity = config.PublicAddress.String() + "_" + uuid.New().String()
+		suffix, err := o.identitySuffix()
+		if err != nil {
+			return fmt.Errorf("resolve node identity: %w", err)
+		}
+		o.NodeIdentity = newNodeIdentity(config.PublicAddress.String(), suffix)
 	}
 
 	lec := &leaderelection.LeaderElectionConfig{
@@ -80,24 +168,117 @@ func (o *electionOptions) ApplyTo(config *RecommendedConfig) error {
 		ReleaseOnCancel: true,
 		Name:            o.Name,
 	}
-	le, leaderElectionClient := newLeaderElection(*lec)
+	leaderElectionClient, err := newLeaderElection(*lec)
+	if err != nil {
+		return fmt.Errorf("create leader election: %w", err)
+	}
 	config.AddHealthChecks(lec.WatchDog)
 	config.LeaderElectionClient = leaderElectionClient
+	config.LeaderLostPolicy = LeaderLostPolicy(o.LostPolicy)
+	config.LeaderHandoverTimeout = o.HandoverTimeout
+	o.applyReadyzTo(config)
 
 	return config.AddPostStartHook("leader-election-hook", func(context genericapiserver.PostStartHookContext) error {
-		go wait.UntilWithContext(wait.ContextForChannel(context.StopCh), le.Run, time.Second)
+		ctx := wait.ContextForChannel(context.StopCh)
+		if o.FastTakeover {
+			leaderElectionClient.takeover(ctx, config.PublicAddress.String(), o.LeaseDuration, o.RetryPeriod)
+		}
+		go leaderElectionClient.run(ctx)
 		return nil
 	})
 }
 
+// applyReadyzTo adds leader check into readyz, or serve it on a separate path,
+// so that only the leader would receive traffic in active/passive mode.
+func (o *electionOptions) applyReadyzTo(config *RecommendedConfig) {
+	leaderCheck := healthz.NamedCheck("leader-election-leading", func(*http.Request) error {
+		if !config.LeaderElectionClient.IsLeader() {
+			return fmt.Errorf("not leading, current leader is %q", config.LeaderElectionClient.GetLeader())
+		}
+		return nil
+	})
+
+	if o.LeaderReadyz {
+		config.AddReadyzChecks(leaderCheck)
+	}
+
+	if o.ReadyzPath != "" {
+		buildHandlerChainFunc := config.BuildHandlerChainFunc
+		config.BuildHandlerChainFunc = func(apiHandler http.Handler, c *genericapiserver.Config) http.Handler {
+			handler := buildHandlerChainFunc(apiHandler, c)
+			// leader readiness served without authentication like other health checks
+			readyzMux := http.NewServeMux()
+			healthz.InstallPathHandler(readyzMux, o.ReadyzPath, healthz.PingHealthz, leaderCheck)
+
+			return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+				if req.URL.Path == o.ReadyzPath || strings.HasPrefix(req.URL.Path, o.ReadyzPath+"/") {
+					readyzMux.ServeHTTP(w, req)
+					return
+				}
+				handler.ServeHTTP(w, req)
+			})
+		}
+	}
+}
+
+// identitySuffix returns the identity suffix from the environments, or from the identity
+// file if exists. A random suffix would be generated and persisted into the identity file.
+func (o *electionOptions) identitySuffix() (string, error) {
+	if len(o.IdentityEnvs) != 0 {
+		values := make([]string, 0, len(o.IdentityEnvs))
+		for _, env := range o.IdentityEnvs {
+			value := os.Getenv(env)
+			if value == "" {
+				return "", fmt.Errorf("environment %s must not be empty", env)
+			}
+			values = append(values, value)
+		}
+		return strings.Join(values, "_"), nil
+	}
+
+	if o.IdentityFile == "" {
+		return uuid.New().String(), nil
+	}
+
+	raw, err := os.ReadFile(o.IdentityFile)
+	if err == nil && strings.TrimSpace(string(raw)) != "" {
+		return strings.TrimSpace(string(raw)), nil
+	}
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return "", err
+	}
+
+	suffix := uuid.New().String()
+	if err := os.MkdirAll(filepath.Dir(o.IdentityFile), 0755); err != nil {
+		return "", err
+	}
+	return suffix, os.WriteFile(o.IdentityFile, []byte(suffix), 0600)
+}
+
+// newNodeIdentity returns identity in format of address_suffix
+func newNodeIdentity(address, suffix string) string {
+	return address + "_" + suffix
+}
+
+// NodeAddressFromIdentity returns the node address from the identity in
+// format of address_suffix, returns nil if the identity not in this format.
+func NodeAddressFromIdentity(identity string) net.IP {
+	address, _, found := strings.Cut(identity, "_")
+	if !found {
+		return nil
+	}
+	return net.ParseIP(address)
+}
+
 type noopElectionClient struct{}
 
 func (noopElectionClient) GetLeader() string                               { return "" }
 func (noopElectionClient) IsLeader() bool                                  { return false }
 func (noopElectionClient) Identity() string                                { return "" }
 func (noopElectionClient) UntilLeadingStateUpdate(sc <-chan struct{}) bool { <-sc; return false }
+func (noopElectionClient) Release(context.Context) error                   { return nil }
 
-func newLeaderElection(lec leaderelection.LeaderElectionConfig) (*leaderelection.LeaderElector, LeaderElectionClient) {
+func newLeaderElection(lec leaderelection.LeaderElectionConfig) (*electionClient, error) {
 	leadingStateUpdateCond := sync.NewCond(&sync.Mutex{})
 	originOnNewLeader := lec.Callbacks.OnNewLeader
 	lec.Callbacks.OnNewLeader = func(identity string) {
@@ -106,19 +287,123 @@ func newLeaderElection(lec leaderelection.LeaderElectionConfig) (*leaderelection
 		}
 		leadingStateUpdateCond.Broadcast()
 	}
-	le := lo.Must(leaderelection.NewLeaderElector(lec))
+	// the lease released on stopped leading never calls the OnNewLeader, notify the
+	// leading state updated so that the followers observe the lease released
+	originOnStoppedLeading := lec.Callbacks.OnStoppedLeading
+	lec.Callbacks.OnStoppedLeading = func() {
+		if originOnStoppedLeading != nil {
+			originOnStoppedLeading()
+		}
+		leadingStateUpdateCond.Broadcast()
+	}
+	le, err := leaderelection.NewLeaderElector(lec)
+	if err != nil {
+		return nil, err
+	}
 	lec.WatchDog.SetLeaderElection(le)
-	return le, &electionClient{
+	runCtx, runCancel := context.WithCancel(context.Background())
+	return &electionClient{
 		Interface:              lec.Lock,
 		LeaderElector:          le,
 		leadingStateUpdateCond: leadingStateUpdateCond,
-	}
+		runCtx:                 runCtx,
+		runCancel:              runCancel,
+		runStopped:             make(chan struct{}),
+	}, nil
 }
 
 type electionClient struct {
 	resourcelock.Interface
 	*leaderelection.LeaderElector
 	leadingStateUpdateCond *sync.Cond
+
+	runStarted atomic.Bool
+	runCtx     context.Context
+	runCancel  context.CancelFunc
+	runStopped chan struct{}
+}
+
+// run keeps the node in election until ctx done or released. The lease
+// would be given up when the run stopped, because ReleaseOnCancel enabled.
+func (c *electionClient) run(ctx context.Context) {
+	if !c.runStarted.CompareAndSwap(false, true) {
+		return
+	}
+	defer close(c.runStopped)
+
+	go func() {
+		select {
+		case <-ctx.Done():
+			c.runCancel()
+		case <-c.runCtx.Done():
+		}
+	}()
+	wait.UntilWithContext(c.runCtx, c.LeaderElector.Run, time.Second)
+}
+
+func (c *electionClient) Release(ctx context.Context) error {
+	c.runCancel()
+	// make sure never wait when the election has not been run
+	if c.runStarted.CompareAndSwap(false, true) {
+		close(c.runStopped)
+	}
+
+	select {
+	case <-c.runStopped:
+		c.leadingStateUpdateCond.Broadcast()
+		return nil
+	case <-ctx.Done():
+		return fmt.Errorf("wait for lease released: %w", ctx.Err())
+	}
+}
+
+// takeover acquires the lease immediately when it held by the same node before
+// restart. The identity of the same node has the same address prefix. Because
+// another live replica may share the address, the lease is only taken over when
+// it has expired, or the holder stops renewing it in two retry periods.
+func (c *electionClient) takeover(ctx context.Context, address string, leaseDuration, retryPeriod time.Duration) {
+	record, _, err := c.Interface.Get(ctx)
+	if err != nil {
+		klog.Infof("skip fast takeover the lease %s: %s", c.Describe(), err)
+		return
+	}
+	if record.HolderIdentity == c.Identity() || !strings.HasPrefix(record.HolderIdentity, newNodeIdentity(address, "")) {
+		return
+	}
+
+	if !leaseExpired(record, time.Now()) {
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(2 * retryPeriod):
+		}
+		observed := record
+		if record, _, err = c.Interface.Get(ctx); err != nil {
+			klog.Infof("skip fast takeover the lease %s: %s", c.Describe(), err)
+			return
+		}
+		if record.HolderIdentity != observed.HolderIdentity || !record.RenewTime.Equal(&observed.RenewTime) {
+			klog.Infof("skip fast takeover the lease %s: holder %s is still renewing", c.Describe(), record.HolderIdentity)
+			return
+		}
+	}
+
+	klog.Infof("fast takeover the lease %s from %s", c.Describe(), record.HolderIdentity)
+	now := metav1.Now()
+	err = c.Interface.Update(ctx, resourcelock.LeaderElectionRecord{
+		HolderIdentity:       c.Identity(),
+		LeaseDurationSeconds: int(leaseDuration / time.Second),
+		AcquireTime:          now,
+		RenewTime:            now,
+		LeaderTransitions:    record.LeaderTransitions + 1,
+	})
+	if err != nil {
+		klog.Errorf("fast takeover the lease %s: %s", c.Describe(), err)
+	}
+}
+
+func leaseExpired(record *resourcelock.LeaderElectionRecord, now time.Time) bool {
+	return record.RenewTime.Add(time.Duration(record.LeaseDurationSeconds) * time.Second).Before(now)
 }
 
 func (c *electionClient) UntilLeadingStateUpdate(stopCh <-chan struct{}) bool {
@@ -1,18 +1,29 @@
 package options_test
 
 import (
+	"context"
+	"net"
+	"net/http"
+	"net/http/httptest"
 	"os"
 	"path/filepath"
 	"testing"
+	"time"
 
 	"github.com/agiledragon/gomonkey/v2"
 	. "github.com/onsi/gomega"
+	"github.com/samber/lo"
 	"github.com/spf13/pflag"
+	coordinationv1 "k8s.io/api/coordination/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/util/rand"
+	"k8s.io/apimachinery/pkg/util/wait"
 	genericapiserver "k8s.io/apiserver/pkg/server"
+	"k8s.io/apiserver/pkg/server/healthz"
 	"k8s.io/client-go/kubernetes"
 	"k8s.io/client-go/kubernetes/fake"
 	"k8s.io/client-go/kubernetes/scheme"
+	"k8s.io/utils/pointer"
 
 	"github.com/everoute/runtime/pkg/options"
 	. "github.com/everoute/runtime/pkg/util/testing"
@@ -56,3 +67,263 @@ func TestNewElectionOptions(t *testing.T) {
 	Eventually(ec.IsLeader).Should(BeTrue())
 	Expect(ec.GetLeader()).Should(Equal(ec.Identity()))
 }
+
+func TestElectionIdentity(t *testing.T) {
+	RegisterTestingT(t)
+
+	tmpPath := PrepareRunServerENV()
+	defer func() { Expect(os.RemoveAll(tmpPath)).ShouldNot(HaveOccurred()) }()
+
+	t.Run("should persist identity into identity file", func(t *testing.T) {
+		identityFile := filepath.Join(tmpPath, rand.String(10), "identity")
+		s1, _ := applyElectionOptions(tmpPath, fake.NewSimpleClientset(), "--election-identity-file="+identityFile)
+		s2, _ := applyElectionOptions(tmpPath, fake.NewSimpleClientset(), "--election-identity-file="+identityFile)
+		Expect(identityFile).Should(BeAnExistingFile())
+		Expect(s1.LeaderElectionClient.Identity()).Should(HavePrefix(s1.PublicAddress.String() + "_"))
+		Expect(s1.LeaderElectionClient.Identity()).Should(Equal(s2.LeaderElectionClient.Identity()))
+	})
+
+	t.Run("should generate identity from environments", func(t *testing.T) {
+		t.Setenv("POD_NAME", rand.String(10))
+		s, _ := applyElectionOptions(tmpPath, fake.NewSimpleClientset(), "--election-identity-envs=POD_NAME")
+		Expect(s.LeaderElectionClient.Identity()).Should(HaveSuffix("_" + os.Getenv("POD_NAME")))
+	})
+}
+
+func TestElectionFastTakeover(t *testing.T) {
+	RegisterTestingT(t)
+
+	tmpPath := PrepareRunServerENV()
+	defer func() { Expect(os.RemoveAll(tmpPath)).ShouldNot(HaveOccurred()) }()
+
+	leaseName := rand.String(20)
+	clientset := fake.NewSimpleClientset()
+	s, hook := applyElectionOptions(tmpPath, clientset, "--election-name="+leaseName, "--election-fast-takeover", "--election-retry-period=100ms")
+
+	// lease held by the same node before restart, and no longer renewed
+	_, err := clientset.CoordinationV1().Leases("kube-system").Create(context.Background(), &coordinationv1.Lease{
+		ObjectMeta: metav1.ObjectMeta{Name: leaseName, Namespace: "kube-system"},
+		Spec: coordinationv1.LeaseSpec{
+			HolderIdentity:       pointer.String(s.PublicAddress.String() + "_" + rand.String(20)),
+			LeaseDurationSeconds: pointer.Int32(3600),
+			AcquireTime:          &metav1.MicroTime{Time: time.Now()},
+			RenewTime:            &metav1.MicroTime{Time: time.Now()},
+		},
+	}, metav1.CreateOptions{})
+	Expect(err).ShouldNot(HaveOccurred())
+
+	stopCh := make(chan struct{})
+	defer close(stopCh)
+	Expect(hook(genericapiserver.PostStartHookContext{StopCh: stopCh})).ShouldNot(HaveOccurred())
+
+	ec := s.LeaderElectionClient
+	Eventually(ec.IsLeader).Should(BeTrue())
+
+	t.Run("should release lease", func(t *testing.T) {
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		releaser, ok := ec.(options.Releaser)
+		Expect(ok).Should(BeTrue())
+		Expect(releaser.Release(ctx)).ShouldNot(HaveOccurred())
+		Expect(ec.IsLeader()).Should(BeFalse())
+
+		lease, err := clientset.CoordinationV1().Leases("kube-system").Get(ctx, leaseName, metav1.GetOptions{})
+		Expect(err).ShouldNot(HaveOccurred())
+		Expect(lease.Spec.HolderIdentity).Should(SatisfyAny(BeNil(), HaveValue(BeEmpty())))
+	})
+}
+
+func TestElectionReleaseNotify(t *testing.T) {
+	RegisterTestingT(t)
+
+	stopCh := make(chan struct{})
+	defer close(stopCh)
+	ec := NewElectionClient(fake.NewSimpleClientset(), net.ParseIP("10.0.0.1"), stopCh, "--election-retry-period=100ms")
+	Eventually(ec.IsLeader).Should(BeTrue())
+
+	released := make(chan struct{})
+	go func() {
+		for ec.UntilLeadingStateUpdate(stopCh) {
+			if ec.GetLeader() == "" {
+				close(released)
+				return
+			}
+		}
+	}()
+	time.Sleep(100 * time.Millisecond) // wait for the notification watched
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	Expect(ec.(options.Releaser).Release(ctx)).ShouldNot(HaveOccurred())
+	Eventually(released).Should(BeClosed())
+}
+
+func TestElectionFastTakeoverLiveHolder(t *testing.T) {
+	RegisterTestingT(t)
+
+	tmpPath := PrepareRunServerENV()
+	defer func() { Expect(os.RemoveAll(tmpPath)).ShouldNot(HaveOccurred()) }()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	leaseName := rand.String(20)
+	clientset := fake.NewSimpleClientset()
+	s, hook := applyElectionOptions(tmpPath, clientset, "--election-name="+leaseName, "--election-fast-takeover", "--election-retry-period=100ms")
+
+	// lease held by another live replica on the same address
+	holder := s.PublicAddress.String() + "_" + rand.String(20)
+	leases := clientset.CoordinationV1().Leases("kube-system")
+	_, err := leases.Create(ctx, &coordinationv1.Lease{
+		ObjectMeta: metav1.ObjectMeta{Name: leaseName, Namespace: "kube-system"},
+		Spec: coordinationv1.LeaseSpec{
+			HolderIdentity:       pointer.String(holder),
+			LeaseDurationSeconds: pointer.Int32(3600),
+			AcquireTime:          &metav1.MicroTime{Time: time.Now()},
+			RenewTime:            &metav1.MicroTime{Time: time.Now()},
+		},
+	}, metav1.CreateOptions{})
+	Expect(err).ShouldNot(HaveOccurred())
+	go wait.UntilWithContext(ctx, func(ctx context.Context) {
+		lease, err := leases.Get(ctx, leaseName, metav1.GetOptions{})
+		if err != nil || lease.Spec.HolderIdentity == nil || *lease.Spec.HolderIdentity != holder {
+			return
+		}
+		lease.Spec.RenewTime = &metav1.MicroTime{Time: time.Now()}
+		_, _ = leases.Update(ctx, lease, metav1.UpdateOptions{})
+	}, 50*time.Millisecond)
+
+	Expect(hook(genericapiserver.PostStartHookContext{StopCh: ctx.Done()})).ShouldNot(HaveOccurred())
+
+	ec := s.LeaderElectionClient
+	Consistently(ec.IsLeader, time.Second).Should(BeFalse())
+	lease, err := leases.Get(ctx, leaseName, metav1.GetOptions{})
+	Expect(err).ShouldNot(HaveOccurred())
+	Expect(lease.Spec.HolderIdentity).Should(HaveValue(Equal(holder)))
+}
+
+func applyElectionOptions(tmpPath string, clientset kubernetes.Interface, args ...string) (*options.RecommendedConfig, genericapiserver.PostStartHookFunc) {
+	opts := options.NewMultipleOptions[*options.RecommendedConfig](options.NewCoreAPIOptions(), options.NewElectionOptions())
+	fs := pflag.NewFlagSet("", pflag.ContinueOnError)
+	opts.AddFlags(fs)
+	s := options.NewRecommendedConfig(scheme.Codecs)
+	s.PublicAddress = net.ParseIP("10.0.0.1")
+	s.BuildHandlerChainFunc = func(apiHandler http.Handler, _ *genericapiserver.Config) http.Handler { return apiHandler }
+
+	var electionPostStartHookFunc genericapiserver.PostStartHookFunc
+	patch := gomonkey.ApplyMethodFunc(&s.Config, "AddPostStartHook", func(name string, hook genericapiserver.PostStartHookFunc) error {
+		electionPostStartHookFunc = hook
+		return nil
+	}).ApplyMethodReturn(&kubernetes.Clientset{}, "CoordinationV1", clientset.CoordinationV1())
+	defer patch.Reset()
+
+	err := fs.Parse(append([]string{
+		"--core-kubeconfig=" + filepath.Join(tmpPath, "kubeconfig"),
+		"--election-enabled",
+		"--election-name=" + rand.String(20),
+	}, args...))
+	Expect(err).ShouldNot(HaveOccurred())
+	Expect(opts.Validate()).Should(HaveLen(0))
+	Expect(opts.ApplyTo(s)).ShouldNot(HaveOccurred())
+	Expect(electionPostStartHookFunc).ShouldNot(BeNil())
+	return s, electionPostStartHookFunc
+}
+
+func TestElectionOptionsValidate(t *testing.T) {
+	RegisterTestingT(t)
+
+	tests := map[string]struct {
+		args      []string
+		errLength int
+	}{
+		"should pass when election disabled": {
+			args:      []string{},
+			errLength: 0,
+		},
+		"should pass with default durations": {
+			args:      []string{"--election-enabled", "--election-name=foo"},
+			errLength: 0,
+		},
+		"should require election name": {
+			args:      []string{"--election-enabled"},
+			errLength: 1,
+		},
+		"should require election namespace": {
+			args:      []string{"--election-enabled", "--election-name=foo", "--election-namespace="},
+			errLength: 1,
+		},
+		"should not specify multiple identity sources": {
+			args:      []string{"--election-enabled", "--election-name=foo", "--election-identity=foo", "--election-identity-envs=POD_NAME"},
+			errLength: 1,
+		},
+		"should require renew deadline less than lease duration": {
+			args:      []string{"--election-enabled", "--election-name=foo", "--election-renew-deadline=15s"},
+			errLength: 1,
+		},
+		"should require renew deadline greater than retry period": {
+			args:      []string{"--election-enabled", "--election-name=foo", "--election-retry-period=9s"},
+			errLength: 1,
+		},
+		"should require retry period greater than zero": {
+			args:      []string{"--election-enabled", "--election-name=foo", "--election-retry-period=0"},
+			errLength: 1,
+		},
+		"should require valid lost policy": {
+			args:      []string{"--election-enabled", "--election-name=foo", "--election-lost-policy=unknown"},
+			errLength: 1,
+		},
+		"should require lease timeout not less than lease duration": {
+			args:      []string{"--election-enabled", "--election-name=foo", "--election-lease-timeout=10s"},
+			errLength: 1,
+		},
+	}
+
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			opts := options.NewElectionOptions()
+			fs := pflag.NewFlagSet("", pflag.ContinueOnError)
+			opts.AddFlags(fs)
+			Expect(fs.Parse(tc.args)).ShouldNot(HaveOccurred())
+			Expect(opts.Validate()).Should(HaveLen(tc.errLength))
+		})
+	}
+}
+
+func TestElectionLeaderReadyz(t *testing.T) {
+	RegisterTestingT(t)
+
+	tmpPath := PrepareRunServerENV()
+	defer func() { Expect(os.RemoveAll(tmpPath)).ShouldNot(HaveOccurred()) }()
+
+	s, hook := applyElectionOptions(tmpPath, fake.NewSimpleClientset(),
+		"--election-leader-readyz",
+		"--election-leader-readyz-path=/readyz-leader",
+	)
+	handler := s.BuildHandlerChainFunc(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		w.WriteHeader(http.StatusTeapot)
+	}), &s.Config)
+	serveStatus := func(path string) int {
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, httptest.NewRequest(http.MethodGet, path, nil))
+		return w.Code
+	}
+	checkNames := lo.Map(s.ReadyzChecks, func(check healthz.HealthChecker, _ int) string { return check.Name() })
+
+	t.Run("should add leader check into readyz", func(t *testing.T) {
+		Expect(checkNames).Should(ContainElement("leader-election-leading"))
+	})
+
+	t.Run("should not ready on leader readyz path when not leading", func(t *testing.T) {
+		Expect(serveStatus("/readyz-leader")).Should(Equal(http.StatusInternalServerError))
+		Expect(serveStatus("/readyz-leader/ping")).Should(Equal(http.StatusOK))
+		Expect(serveStatus("/readyz")).Should(Equal(http.StatusTeapot))
+	})
+
+	t.Run("should ready on leader readyz path when leading", func(t *testing.T) {
+		stopCh := make(chan struct{})
+		defer close(stopCh)
+		Expect(hook(genericapiserver.PostStartHookContext{StopCh: stopCh})).ShouldNot(HaveOccurred())
+		Eventually(s.LeaderElectionClient.IsLeader).Should(BeTrue())
+		Expect(serveStatus("/readyz-leader")).Should(Equal(http.StatusOK))
+	})
+}
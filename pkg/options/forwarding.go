@@ -0,0 +1,146 @@
+package options
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httputil"
+
+	"github.com/spf13/pflag"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	utilnet "k8s.io/apimachinery/pkg/util/net"
+	"k8s.io/apimachinery/pkg/util/sets"
+	"k8s.io/apiserver/pkg/endpoints/handlers/responsewriters"
+	"k8s.io/apiserver/pkg/endpoints/request"
+	genericapiserver "k8s.io/apiserver/pkg/server"
+	"k8s.io/client-go/transport"
+	"k8s.io/klog/v2"
+)
+
+const (
+	// LeaderForwardingMutating forwards mutating resource requests to the leader
+	LeaderForwardingMutating = "mutating"
+	// LeaderForwardingAll forwards all resource requests to the leader
+	LeaderForwardingAll = "all"
+
+	// forwardedByHeader records the node identity which forwarded the request
+	forwardedByHeader = "X-Everoute-Forwarded-By"
+)
+
+var mutatingVerbs = sets.New("create", "update", "patch", "delete", "deletecollection")
+
+func NewLeaderForwardingOptions() Options {
+	return &leaderForwardingOptions{}
+}
+
+type leaderForwardingOptions struct {
+	Mode string
+}
+
+func (o *leaderForwardingOptions) AddFlags(flagSet *pflag.FlagSet) {
+	flagSet.StringVar(&o.Mode, "leader-forwarding-mode", "", "forward requests on followers to the leader, "+
+		"one of: mutating, all. The election identity must be in form <address>_<suffix>")
+}
+
+func (o *leaderForwardingOptions) Validate() []error {
+	switch o.Mode {
+	case "", LeaderForwardingMutating, LeaderForwardingAll:
+		return nil
+	default:
+		return []error{fmt.Errorf("invalid leader forwarding mode %q", o.Mode)}
+	}
+}
+
+func (o *leaderForwardingOptions) ApplyTo(config *RecommendedConfig) error {
+	if o.Mode == "" {
+		return nil
+	}
+	// the election client is noop when the leader election disabled
+	if _, disabled := config.LeaderElectionClient.(noopElectionClient); disabled || config.LeaderElectionClient == nil {
+		return fmt.Errorf("leader forwarding requires leader election")
+	}
+	// the leader address is resolved from its identity, the same as the current node
+	if identity := config.LeaderElectionClient.Identity(); NodeAddressFromIdentity(identity) == nil {
+		return fmt.Errorf("leader forwarding requires the election identity in form <address>_<suffix>, got %q", identity)
+	}
+	tlsConfig, err := config.PeerTLSConfig()
+	if err != nil {
+		return fmt.Errorf("leader forwarding: %w", err)
+	}
+	rt := utilnet.SetTransportDefaults(&http.Transport{TLSClientConfig: tlsConfig})
+
+	buildHandlerChainFunc := config.BuildHandlerChainFunc
+	config.BuildHandlerChainFunc = func(apiHandler http.Handler, c *genericapiserver.Config) http.Handler {
+		// forwarding after the request authenticated, so that the user could be impersonated on the leader
+		return buildHandlerChainFunc(o.withLeaderForwarding(apiHandler, config, rt), c)
+	}
+	return nil
+}
+
+func (o *leaderForwardingOptions) withLeaderForwarding(handler http.Handler, config *RecommendedConfig, rt http.RoundTripper) http.Handler {
+	electionClient := config.LeaderElectionClient
+
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		requestInfo, ok := request.RequestInfoFrom(req.Context())
+		if !ok || !o.shouldForward(requestInfo) || electionClient.IsLeader() {
+			handler.ServeHTTP(w, req)
+			return
+		}
+		gv := schema.GroupVersion{Group: requestInfo.APIGroup, Version: requestInfo.APIVersion}
+
+		// never forward again, avoid loops when leader changed
+		if forwardedBy := req.Header.Get(forwardedByHeader); forwardedBy != "" {
+			err := fmt.Errorf("request forwarded by %s but current node is not leading", forwardedBy)
+			responsewriters.ErrorNegotiated(apierrors.NewServiceUnavailable(err.Error()), config.Serializer, gv, w, req)
+			return
+		}
+
+		leader := electionClient.GetLeader()
+		target, err := config.PeerEndpoint(leader)
+		if err != nil {
+			err = fmt.Errorf("unable to forward to leader %q: %w", leader, err)
+			responsewriters.ErrorNegotiated(apierrors.NewServiceUnavailable(err.Error()), config.Serializer, gv, w, req)
+			return
+		}
+
+		klog.V(4).Infof("forward request %s %s to leader %s", req.Method, req.URL.Path, leader)
+		proxy := httputil.NewSingleHostReverseProxy(target)
+		proxy.Transport = impersonatingTransport(req, rt)
+		proxy.FlushInterval = -1 // flush immediately for watch requests
+		proxy.ErrorHandler = func(w http.ResponseWriter, req *http.Request, err error) {
+			err = fmt.Errorf("forward to leader %q: %w", leader, err)
+			responsewriters.ErrorNegotiated(apierrors.NewServiceUnavailable(err.Error()), config.Serializer, gv, w, req)
+		}
+		proxy.ServeHTTP(w, forwardRequest(req, electionClient.Identity()))
+	})
+}
+
+func (o *leaderForwardingOptions) shouldForward(requestInfo *request.RequestInfo) bool {
+	if !requestInfo.IsResourceRequest {
+		return false
+	}
+	return o.Mode == LeaderForwardingAll || mutatingVerbs.Has(requestInfo.Verb)
+}
+
+// forwardRequest returns the request forwarded to the leader, the credentials
+// are dropped and the authenticated user would be impersonated on the leader.
+func forwardRequest(req *http.Request, identity string) *http.Request {
+	req = req.Clone(req.Context())
+	req.Header.Del("Authorization")
+	req.Header.Set(forwardedByHeader, identity)
+	return req
+}
+
+// impersonatingTransport returns transport impersonating the authenticated user
+func impersonatingTransport(req *http.Request, rt http.RoundTripper) http.RoundTripper {
+	user, ok := request.UserFrom(req.Context())
+	if !ok {
+		return rt
+	}
+	return transport.NewImpersonatingRoundTripper(transport.ImpersonationConfig{
+		UserName: user.GetName(),
+		UID:      user.GetUID(),
+		Groups:   user.GetGroups(),
+		Extra:    user.GetExtra(),
+	}, rt)
+}
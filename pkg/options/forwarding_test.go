@@ -0,0 +1,123 @@
+package options_test
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	. "github.com/onsi/gomega"
+	"github.com/spf13/pflag"
+	authenticationv1 "k8s.io/api/authentication/v1"
+	"k8s.io/apimachinery/pkg/util/rand"
+	"k8s.io/apimachinery/pkg/util/sets"
+	"k8s.io/apiserver/pkg/authentication/user"
+	genericapifilters "k8s.io/apiserver/pkg/endpoints/filters"
+	"k8s.io/apiserver/pkg/endpoints/request"
+	genericapiserver "k8s.io/apiserver/pkg/server"
+	"k8s.io/client-go/kubernetes/scheme"
+
+	"github.com/everoute/runtime/pkg/options"
+	. "github.com/everoute/runtime/pkg/util/testing"
+)
+
+func TestLeaderForwardingOptions(t *testing.T) {
+	RegisterTestingT(t)
+
+	peer := NewPeerServer(t, http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		_, _ = io.WriteString(w, strings.Join([]string{
+			req.Method,
+			req.URL.Path,
+			req.Header.Get(authenticationv1.ImpersonateUserHeader),
+			strings.Join(req.Header.Values(authenticationv1.ImpersonateGroupHeader), ","),
+			req.TLS.PeerCertificates[0].Subject.String(),
+		}, " "))
+	}))
+
+	electionClient := NewFakeLeaderElectionClient("127.0.0.1_" + rand.String(10))
+	electionClient.SetLeader(peer.Identity())
+
+	newHandler := func(mode string) http.Handler {
+		s := options.NewRecommendedConfig(scheme.Codecs)
+		s.LeaderElectionClient = electionClient
+		s.SecureServing = peer.LocalSecureServing()
+		s.BuildHandlerChainFunc = func(apiHandler http.Handler, _ *genericapiserver.Config) http.Handler {
+			return genericapifilters.WithRequestInfo(apiHandler, &request.RequestInfoFactory{
+				APIPrefixes:          sets.NewString("api", "apis"),
+				GrouplessAPIPrefixes: sets.NewString("api"),
+			})
+		}
+
+		opts := options.NewLeaderForwardingOptions()
+		fs := pflag.NewFlagSet("", pflag.ContinueOnError)
+		opts.AddFlags(fs)
+		Expect(fs.Parse([]string{"--leader-forwarding-mode=" + mode})).ShouldNot(HaveOccurred())
+		Expect(opts.Validate()).Should(HaveLen(0))
+		Expect(opts.ApplyTo(s)).ShouldNot(HaveOccurred())
+
+		return s.BuildHandlerChainFunc(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+			_, _ = io.WriteString(w, "local")
+		}), &s.Config)
+	}
+	serve := func(handler http.Handler, method, path string) string {
+		req := httptest.NewRequest(method, path, nil)
+		req = req.WithContext(request.WithUser(req.Context(), &user.DefaultInfo{Name: "foo", Groups: []string{"bar"}}))
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, req)
+		return w.Body.String()
+	}
+
+	t.Run("should forward mutating requests to leader", func(t *testing.T) {
+		handler := newHandler(options.LeaderForwardingMutating)
+		Expect(serve(handler, http.MethodPost, "/api/v1/namespaces/default/configmaps")).Should(HavePrefix("POST /api/v1/namespaces/default/configmaps foo bar"))
+		Expect(serve(handler, http.MethodGet, "/api/v1/namespaces/default/configmaps")).Should(Equal("local"))
+		Expect(serve(handler, http.MethodPost, "/healthz")).Should(Equal("local"))
+	})
+
+	t.Run("should forward all resource requests to leader", func(t *testing.T) {
+		handler := newHandler(options.LeaderForwardingAll)
+		Expect(serve(handler, http.MethodGet, "/api/v1/namespaces/default/configmaps")).Should(HavePrefix("GET /api/v1/namespaces/default/configmaps foo bar"))
+		Expect(serve(handler, http.MethodGet, "/readyz")).Should(Equal("local"))
+	})
+
+	t.Run("should serve locally when leading", func(t *testing.T) {
+		handler := newHandler(options.LeaderForwardingAll)
+		electionClient.SetLeader(electionClient.Identity())
+		defer electionClient.SetLeader(peer.Identity())
+		Expect(serve(handler, http.MethodPost, "/api/v1/namespaces/default/configmaps")).Should(Equal("local"))
+	})
+
+	t.Run("should not forward when leader unknown", func(t *testing.T) {
+		handler := newHandler(options.LeaderForwardingAll)
+		electionClient.SetLeader("")
+		defer electionClient.SetLeader(peer.Identity())
+		Expect(serve(handler, http.MethodPost, "/api/v1/namespaces/default/configmaps")).Should(ContainSubstring("unable to forward to leader"))
+	})
+
+	t.Run("should reject identity without node address", func(t *testing.T) {
+		s := options.NewRecommendedConfig(scheme.Codecs)
+		s.LeaderElectionClient = NewFakeLeaderElectionClient(rand.String(10))
+		s.SecureServing = peer.LocalSecureServing()
+
+		opts := options.NewLeaderForwardingOptions()
+		fs := pflag.NewFlagSet("", pflag.ContinueOnError)
+		opts.AddFlags(fs)
+		Expect(fs.Parse([]string{"--leader-forwarding-mode=" + options.LeaderForwardingAll})).ShouldNot(HaveOccurred())
+		Expect(opts.ApplyTo(s)).Should(MatchError(ContainSubstring("requires the election identity")))
+	})
+
+	t.Run("should reject when leader election disabled", func(t *testing.T) {
+		s := options.NewRecommendedConfig(scheme.Codecs)
+		s.SecureServing = peer.LocalSecureServing()
+		electionOpts := options.NewElectionOptions()
+		electionOpts.AddFlags(pflag.NewFlagSet("", pflag.ContinueOnError))
+		Expect(electionOpts.ApplyTo(s)).ShouldNot(HaveOccurred())
+
+		opts := options.NewLeaderForwardingOptions()
+		fs := pflag.NewFlagSet("", pflag.ContinueOnError)
+		opts.AddFlags(fs)
+		Expect(fs.Parse([]string{"--leader-forwarding-mode=" + options.LeaderForwardingAll})).ShouldNot(HaveOccurred())
+		Expect(opts.ApplyTo(s)).Should(MatchError("leader forwarding requires leader election"))
+	})
+}
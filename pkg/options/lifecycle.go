@@ -0,0 +1,52 @@
+package options
+
+import (
+	"context"
+
+	"github.com/spf13/pflag"
+	"k8s.io/apimachinery/pkg/util/wait"
+	genericapiserver "k8s.io/apiserver/pkg/server"
+
+	"github.com/everoute/runtime/pkg/lifecycle"
+)
+
+// NewLifecycleOptions hooks the lifecycle registry into the apiserver, it must be applied before
+// the election options. The pre-shutdown and shutdown phases are called by server.GracefulShutdown.
+func NewLifecycleOptions() Options {
+	return &lifecycleOptions{}
+}
+
+type lifecycleOptions struct{}
+
+func (o *lifecycleOptions) AddFlags(*pflag.FlagSet) {}
+func (o *lifecycleOptions) Validate() []error       { return nil }
+
+func (o *lifecycleOptions) ApplyTo(config *RecommendedConfig) error {
+	if config.Lifecycle == nil {
+		return nil
+	}
+	if err := config.Lifecycle.Validate(); err != nil {
+		return err
+	}
+	registry := config.Lifecycle
+
+	originOnStartedLeading := config.LeaderCallbacks.OnStartedLeading
+	config.LeaderCallbacks.OnStartedLeading = func(ctx context.Context) {
+		_ = registry.Run(ctx, lifecycle.PhaseStartedLeading)
+		if originOnStartedLeading != nil {
+			originOnStartedLeading(ctx)
+		}
+	}
+	originOnStoppedLeading := config.LeaderCallbacks.OnStoppedLeading
+	config.LeaderCallbacks.OnStoppedLeading = func() {
+		_ = registry.Run(context.Background(), lifecycle.PhaseStoppedLeading)
+		if originOnStoppedLeading != nil {
+			originOnStoppedLeading()
+		}
+	}
+
+	config.AddHealthChecks(registry)
+	return config.AddPostStartHook("lifecycle-post-start-hook", func(context genericapiserver.PostStartHookContext) error {
+		return registry.Run(wait.ContextForChannel(context.StopCh), lifecycle.PhasePostStart)
+	})
+}
@@ -0,0 +1,42 @@
+package options_test
+
+import (
+	"context"
+	"testing"
+
+	. "github.com/onsi/gomega"
+	"go.uber.org/atomic"
+	"k8s.io/client-go/kubernetes/scheme"
+
+	"github.com/everoute/runtime/pkg/lifecycle"
+	"github.com/everoute/runtime/pkg/options"
+)
+
+func TestLifecycleOptionsLeaderPhases(t *testing.T) {
+	RegisterTestingT(t)
+
+	var started, stopped atomic.Int32
+	config := options.NewRecommendedConfig(scheme.Codecs)
+	Expect(config.Lifecycle.Register(lifecycle.Hook{
+		Name:  "counter",
+		Phase: lifecycle.PhaseStartedLeading,
+		Func:  func(context.Context) error { started.Inc(); return nil },
+	})).ShouldNot(HaveOccurred())
+	Expect(config.Lifecycle.Register(lifecycle.Hook{
+		Name:  "counter",
+		Phase: lifecycle.PhaseStoppedLeading,
+		Func:  func(context.Context) error { stopped.Inc(); return nil },
+	})).ShouldNot(HaveOccurred())
+	Expect(options.NewLifecycleOptions().ApplyTo(config)).ShouldNot(HaveOccurred())
+
+	t.Run("should call stopped-leading hooks when stopped leading", func(t *testing.T) {
+		config.LeaderCallbacks.OnStoppedLeading()
+		Expect(stopped.Load()).Should(Equal(int32(1)))
+		Expect(started.Load()).Should(BeZero())
+	})
+
+	t.Run("should call started-leading hooks when leading again", func(t *testing.T) {
+		config.LeaderCallbacks.OnStartedLeading(context.Background())
+		Expect(started.Load()).Should(Equal(int32(1)))
+	})
+}
@@ -0,0 +1,99 @@
+package options
+
+import (
+	"reflect"
+
+	"github.com/spf13/pflag"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	apimachineryversion "k8s.io/apimachinery/pkg/version"
+	openapinamer "k8s.io/apiserver/pkg/endpoints/openapi"
+	genericapiserver "k8s.io/apiserver/pkg/server"
+	"k8s.io/klog/v2"
+	"k8s.io/kube-openapi/pkg/common"
+	openapiutil "k8s.io/kube-openapi/pkg/util"
+	"k8s.io/kube-openapi/pkg/validation/spec"
+
+	"github.com/everoute/runtime/pkg/version"
+)
+
+// NewOpenAPIOptions configures the openapi v2 and v3 specs with the generated definitions, the
+// definition names are resolved from the schemes. Types registered in the schemes and types served
+// by the apiserver fallback to objects preserve unknown fields if missing in the definitions.
+func NewOpenAPIOptions(title string, getDefinitions common.GetOpenAPIDefinitions, schemes ...*runtime.Scheme) Options {
+	return &openAPIOptions{
+		title:          title,
+		getDefinitions: getDefinitions,
+		schemes:        schemes,
+	}
+}
+
+type openAPIOptions struct {
+	title          string
+	getDefinitions common.GetOpenAPIDefinitions
+	schemes        []*runtime.Scheme
+}
+
+func (o *openAPIOptions) AddFlags(*pflag.FlagSet) {}
+func (o *openAPIOptions) Validate() []error       { return nil }
+
+func (o *openAPIOptions) ApplyTo(config *RecommendedConfig) error {
+	getDefinitions := withFallbackDefinitions(o.getDefinitions, o.schemes)
+	namer := openapinamer.NewDefinitionNamer(o.schemes...)
+	versionInfo := version.GetVersionInfo()
+
+	config.OpenAPIConfig = genericapiserver.DefaultOpenAPIConfig(getDefinitions, namer)
+	config.OpenAPIConfig.Info.Title = o.title
+	config.OpenAPIConfig.Info.Version = versionInfo.GitVersion
+
+	config.OpenAPIV3Config = genericapiserver.DefaultOpenAPIV3Config(getDefinitions, namer)
+	config.OpenAPIV3Config.Info.Title = o.title
+	config.OpenAPIV3Config.Info.Version = versionInfo.GitVersion
+	return nil
+}
+
+// fallbackTypes are served by the apiserver but may not registered in the schemes
+var fallbackTypes = []interface{}{
+	&metav1.Status{},
+	&metav1.APIVersions{},
+	&metav1.APIGroupList{},
+	&metav1.APIGroup{},
+	&metav1.APIResourceList{},
+	&metav1.Patch{},
+	&metav1.WatchEvent{},
+	&metav1.DeleteOptions{},
+	&apimachineryversion.Info{},
+}
+
+// withFallbackDefinitions adds definitions of objects preserve unknown fields for the types
+// missing in the definitions, make sure the openapi could be built without all definitions.
+func withFallbackDefinitions(getDefinitions common.GetOpenAPIDefinitions, schemes []*runtime.Scheme) common.GetOpenAPIDefinitions {
+	return func(ref common.ReferenceCallback) map[string]common.OpenAPIDefinition {
+		definitions := make(map[string]common.OpenAPIDefinition)
+		if getDefinitions != nil {
+			for name, definition := range getDefinitions(ref) {
+				definitions[name] = definition
+			}
+		}
+
+		models := append([]interface{}{}, fallbackTypes...)
+		for _, scheme := range schemes {
+			for _, t := range scheme.AllKnownTypes() {
+				models = append(models, reflect.New(t).Interface())
+			}
+		}
+		for _, model := range models {
+			name := openapiutil.GetCanonicalTypeName(model)
+			if _, ok := definitions[name]; !ok {
+				klog.V(4).Infof("openapi definition of %s not found, fallback to object preserve unknown fields", name)
+				definitions[name] = common.OpenAPIDefinition{
+					Schema: spec.Schema{
+						SchemaProps:      spec.SchemaProps{Type: []string{"object"}},
+						VendorExtensible: spec.VendorExtensible{Extensions: spec.Extensions{"x-kubernetes-preserve-unknown-fields": true}},
+					},
+				}
+			}
+		}
+		return definitions
+	}
+}
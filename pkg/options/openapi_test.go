@@ -0,0 +1,43 @@
+package options_test
+
+import (
+	"testing"
+
+	. "github.com/onsi/gomega"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/scheme"
+	"k8s.io/kube-openapi/pkg/common"
+	openapiutil "k8s.io/kube-openapi/pkg/util"
+	"k8s.io/kube-openapi/pkg/validation/spec"
+
+	"github.com/everoute/runtime/pkg/options"
+	"github.com/everoute/runtime/pkg/version"
+)
+
+func TestOpenAPIOptions(t *testing.T) {
+	RegisterTestingT(t)
+
+	podName := openapiutil.GetCanonicalTypeName(&corev1.Pod{})
+	getDefinitions := func(common.ReferenceCallback) map[string]common.OpenAPIDefinition {
+		return map[string]common.OpenAPIDefinition{
+			podName: {Schema: spec.Schema{SchemaProps: spec.SchemaProps{Description: "generated"}}},
+		}
+	}
+
+	config := options.NewRecommendedConfig(scheme.Codecs)
+	Expect(options.NewOpenAPIOptions("unittest", getDefinitions, scheme.Scheme).ApplyTo(config)).ShouldNot(HaveOccurred())
+
+	for _, openAPIConfig := range []*common.Config{config.OpenAPIConfig, config.OpenAPIV3Config} {
+		Expect(openAPIConfig).ShouldNot(BeNil())
+		Expect(openAPIConfig.Info.Title).Should(Equal("unittest"))
+		Expect(openAPIConfig.Info.Version).Should(Equal(version.GetVersionInfo().GitVersion))
+
+		definitions := openAPIConfig.GetDefinitions(func(path string) spec.Ref { return spec.MustCreateRef(path) })
+		Expect(definitions[podName].Schema.Description).Should(Equal("generated"))
+		Expect(definitions).Should(HaveKey(openapiutil.GetCanonicalTypeName(&corev1.Service{})))
+		Expect(definitions).Should(HaveKey(openapiutil.GetCanonicalTypeName(&metav1.Status{})))
+		Expect(definitions[openapiutil.GetCanonicalTypeName(&metav1.Status{})].Schema.Extensions).
+			Should(HaveKeyWithValue("x-kubernetes-preserve-unknown-fields", true))
+	}
+}
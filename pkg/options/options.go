@@ -2,6 +2,7 @@ package options
 
 import (
 	"flag"
+	"time"
 
 	"github.com/spf13/pflag"
 	"k8s.io/apimachinery/pkg/runtime"
@@ -13,7 +14,10 @@ import (
 	"k8s.io/client-go/kubernetes"
 	"k8s.io/client-go/tools/leaderelection"
 	"k8s.io/client-go/util/flowcontrol"
+	cliflag "k8s.io/component-base/cli/flag"
 	"k8s.io/klog/v2"
+
+	"github.com/everoute/runtime/pkg/lifecycle"
 )
 
 // RecommendedConfig is a structure used to configure a GenericAPIServer
@@ -23,8 +27,24 @@ type RecommendedConfig struct {
 	Clientset            kubernetes.Interface
 	LeaderElectionClient LeaderElectionClient
 	LeaderCallbacks      leaderelection.LeaderCallbacks
+
+	// LeaderLostPolicy decides what to do when another node becomes leader, the
+	// stopped-leading lifecycle hooks are called under any policy
+	LeaderLostPolicy LeaderLostPolicy
+	// LeaderHandoverTimeout is the max duration to wait for the new leader ready before shutdown
+	LeaderHandoverTimeout time.Duration
+
+	// Lifecycle registers hooks called in apiserver lifecycle phases
+	Lifecycle *lifecycle.Registry
+
+	// delegateFuncs create the delegation chain of the apiserver
+	delegateFuncs []DelegateFunc
 }
 
+// DelegateFunc creates an apiserver which handles requests not handled by the apiserver,
+// and delegates requests it not handled to the delegate
+type DelegateFunc func(delegate genericapiserver.DelegationTarget) (genericapiserver.DelegationTarget, error)
+
 // NewRecommendedConfig returns a RecommendedConfig struct with the default values
 func NewRecommendedConfig(codecs serializer.CodecFactory) *RecommendedConfig {
 	config := genericapiserver.NewRecommendedConfig(codecs)
@@ -32,7 +52,28 @@ func NewRecommendedConfig(codecs serializer.CodecFactory) *RecommendedConfig {
 	return &RecommendedConfig{
 		RecommendedConfig: *config,
 		LeaderCallbacks:   leaderelection.LeaderCallbacks{},
+		LeaderLostPolicy:  LeaderLostPolicyExit,
+		Lifecycle:         lifecycle.NewRegistry(),
+	}
+}
+
+// AddDelegate adds an apiserver into the delegation chain, it's called when the apiserver
+// created, the apiserver added first is the nearest to the apiserver
+func (c *RecommendedConfig) AddDelegate(fn DelegateFunc) {
+	c.delegateFuncs = append(c.delegateFuncs, fn)
+}
+
+// NewDelegationTarget creates the delegation chain, returns an empty delegate if no
+// apiserver added. It should be used to create the apiserver from the config.
+func (c *RecommendedConfig) NewDelegationTarget() (genericapiserver.DelegationTarget, error) {
+	delegate := genericapiserver.NewEmptyDelegate()
+	for i := len(c.delegateFuncs) - 1; i >= 0; i-- {
+		var err error
+		if delegate, err = c.delegateFuncs[i](delegate); err != nil {
+			return nil, err
+		}
 	}
+	return delegate, nil
 }
 
 // Options contains the options for running an API server
@@ -60,17 +101,29 @@ func (o convertOptions[T, R]) ApplyTo(config R) error {
 	return o.GenericOptions.ApplyTo(o.convert(config))
 }
 
-func NewRecommendedOptions(prefix string, codec runtime.Codec) Options {
-	return NewMultipleOptions[*RecommendedConfig](
-		NewKLogOptions[*RecommendedConfig](),
-		NewCoreAPIOptions(),
-		NewAuditOptions(),
-		NewSecureServingOptions(),
-		NewFeatureOptions(),
-		NewAuthenticationOptions(),
-		NewEtcdOptions(storagebackend.NewDefaultConfig(prefix, codec)),
-		NewElectionOptions(),
+// NewRecommendedOptions returns the recommended options of the apiserver. The extraOptions are
+// applied after the core api options and before the election options, so that they could use
+// the clientset and hook into the leader callbacks.
+func NewRecommendedOptions(prefix string, codec runtime.Codec, extraOptions ...Options) Options {
+	opts := []GenericOptions[*RecommendedConfig]{
+		NewNamedOptions[*RecommendedConfig]("logging", NewKLogOptions[*RecommendedConfig]()),
+		NewNamedOptions[*RecommendedConfig]("core api", NewCoreAPIOptions()),
+		NewNamedOptions[*RecommendedConfig]("audit", NewAuditOptions()),
+		NewNamedOptions[*RecommendedConfig]("secure serving", NewSecureServingOptions()),
+		NewNamedOptions[*RecommendedConfig]("features", NewFeatureOptions()),
+		NewNamedOptions[*RecommendedConfig]("authentication", NewAuthenticationOptions()),
+		NewNamedOptions[*RecommendedConfig]("etcd", NewEtcdOptions(storagebackend.NewDefaultConfig(prefix, codec))),
+	}
+	for _, o := range extraOptions {
+		opts = append(opts, o)
+	}
+	opts = append(opts,
+		NewLifecycleOptions(),
+		NewNamedOptions[*RecommendedConfig]("leader election", NewElectionOptions()),
+		NewNamedOptions[*RecommendedConfig]("leader election", NewLeaderForwardingOptions()),
+		NewNamedOptions[*RecommendedConfig]("shutdown", NewShutdownOptions()),
 	)
+	return NewMultipleOptions[*RecommendedConfig](opts...)
 }
 
 func NewMultipleOptions[T any](opts ...GenericOptions[T]) GenericOptions[T] {
@@ -104,6 +157,31 @@ func (o *multipleOptions[T]) ApplyTo(config T) error {
 	return nil
 }
 
+// NewNamedOptions names the options, the name used as the flags section by AddNamedFlags
+func NewNamedOptions[T any](name string, opts GenericOptions[T]) GenericOptions[T] {
+	return &namedOptions[T]{GenericOptions: opts, name: name}
+}
+
+type namedOptions[T any] struct {
+	GenericOptions[T]
+	name string
+}
+
+// AddNamedFlags adds flags of the options into the named flag sets by the option names,
+// flags of the options without name are added into the flag set of the defaultName.
+func AddNamedFlags[T any](opts GenericOptions[T], fss *cliflag.NamedFlagSets, defaultName string) {
+	switch o := opts.(type) {
+	case *namedOptions[T]:
+		AddNamedFlags(o.GenericOptions, fss, o.name)
+	case *multipleOptions[T]:
+		for _, child := range o.options {
+			AddNamedFlags(child, fss, defaultName)
+		}
+	default:
+		opts.AddFlags(fss.FlagSet(defaultName))
+	}
+}
+
 func NewKLogOptions[T any]() GenericOptions[T] {
 	return &klogOptions[T]{}
 }
@@ -5,6 +5,7 @@ import (
 	"os"
 	"path/filepath"
 	"testing"
+	"time"
 
 	. "github.com/onsi/gomega"
 	"github.com/spf13/pflag"
@@ -38,6 +39,8 @@ func TestNewRecommendedOptions(t *testing.T) {
 		"--authentication-enabled",
 		"--etcd-servers=http://127.0.0.1:2379",
 		"--election-enabled",
+		"--election-name=unittest",
+		"--shutdown-delay-duration=5s",
 	})
 	Expect(err).ShouldNot(HaveOccurred())
 	Expect(opts.Validate()).Should(HaveLen(0))
@@ -73,6 +76,10 @@ func TestNewRecommendedOptions(t *testing.T) {
 		Expect(s.Authentication.Authenticator).ShouldNot(BeNil())
 	})
 
+	t.Run("should set shutdown options", func(t *testing.T) {
+		Expect(s.ShutdownDelayDuration).Should(Equal(5 * time.Second))
+	})
+
 	t.Run("should set etcd options", func(t *testing.T) {
 		Expect(s.RESTOptionsGetter).ShouldNot(BeNil())
 	})
@@ -2,8 +2,10 @@ package options
 
 import (
 	"crypto/tls"
+	"crypto/x509"
 	"fmt"
 	"net"
+	"net/url"
 	"os"
 
 	"github.com/google/uuid"
@@ -91,3 +93,43 @@ func (o *secureServingOptions) ApplyTo(config *RecommendedConfig) error {
 	config.LoopbackClientConfig.TLSClientConfig.ServerName = "127.0.0.1"
 	return nil
 }
+
+// PeerTLSConfig returns the tls config to communicate with other nodes, the
+// serving certificate used as client certificate, and the client CA as root CAs.
+func (c *RecommendedConfig) PeerTLSConfig() (*tls.Config, error) {
+	if c.SecureServing == nil || c.SecureServing.Cert == nil || c.SecureServing.ClientCA == nil {
+		return nil, fmt.Errorf("secure serving with cert and client CA is required")
+	}
+	caContent := c.SecureServing.ClientCA.CurrentCABundleContent()
+	rootCAs := x509.NewCertPool()
+	if !rootCAs.AppendCertsFromPEM(caContent) {
+		return nil, fmt.Errorf("invalid client CA content")
+	}
+
+	certProvider := c.SecureServing.Cert
+	return &tls.Config{
+		MinVersion: c.SecureServing.MinTLSVersion,
+		RootCAs:    rootCAs,
+		GetClientCertificate: func(*tls.CertificateRequestInfo) (*tls.Certificate, error) {
+			cert, err := tls.X509KeyPair(certProvider.CurrentCertKeyContent())
+			return &cert, err
+		},
+	}, nil
+}
+
+// PeerEndpoint returns the endpoint of the node with identity, the node address
+// resolved from the identity, and all nodes are expected to serve on the same port.
+func (c *RecommendedConfig) PeerEndpoint(identity string) (*url.URL, error) {
+	address := NodeAddressFromIdentity(identity)
+	if address == nil {
+		return nil, fmt.Errorf("unable to resolve address from identity %q", identity)
+	}
+	if c.SecureServing == nil || c.SecureServing.Listener == nil {
+		return nil, fmt.Errorf("secure serving listener is required")
+	}
+	_, port, err := net.SplitHostPort(c.SecureServing.Listener.Addr().String())
+	if err != nil {
+		return nil, err
+	}
+	return &url.URL{Scheme: "https", Host: net.JoinHostPort(address.String(), port)}, nil
+}
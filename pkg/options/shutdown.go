@@ -0,0 +1,34 @@
+package options
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/spf13/pflag"
+)
+
+// NewShutdownOptions returns options of the apiserver graceful shutdown
+func NewShutdownOptions() Options {
+	return &shutdownOptions{}
+}
+
+type shutdownOptions struct {
+	ShutdownDelayDuration time.Duration
+}
+
+func (o *shutdownOptions) AddFlags(flagSet *pflag.FlagSet) {
+	flagSet.DurationVar(&o.ShutdownDelayDuration, "shutdown-delay-duration", 0, "time to delay the shutdown after not-ready, "+
+		"the apiserver keeps serving requests normally during this time, gives load balancers time to stop sending traffic")
+}
+
+func (o *shutdownOptions) Validate() []error {
+	if o.ShutdownDelayDuration < 0 {
+		return []error{fmt.Errorf("--shutdown-delay-duration can not be negative value")}
+	}
+	return nil
+}
+
+func (o *shutdownOptions) ApplyTo(config *RecommendedConfig) error {
+	config.ShutdownDelayDuration = o.ShutdownDelayDuration
+	return nil
+}
@@ -0,0 +1,311 @@
+package registry
+
+import (
+	"bytes"
+	"context"
+	"crypto/subtle"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/samber/lo"
+	"github.com/spf13/pflag"
+	"k8s.io/apiextensions-apiserver/pkg/apis/apiextensions"
+	apiextensionsv1 "k8s.io/apiextensions-apiserver/pkg/apis/apiextensions/v1"
+	"k8s.io/apiextensions-apiserver/pkg/apis/apiextensions/v1beta1"
+	apiextensionsvalidation "k8s.io/apiextensions-apiserver/pkg/apis/apiextensions/validation"
+	apiextensionsapiserver "k8s.io/apiextensions-apiserver/pkg/apiserver"
+	apiextensionsclientset "k8s.io/apiextensions-apiserver/pkg/client/clientset/clientset"
+	apiextensionsopenapi "k8s.io/apiextensions-apiserver/pkg/generated/openapi"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/util/sets"
+	"k8s.io/apimachinery/pkg/util/wait"
+	utilyaml "k8s.io/apimachinery/pkg/util/yaml"
+	"k8s.io/apiserver/pkg/authentication/user"
+	discoveryendpoint "k8s.io/apiserver/pkg/endpoints/discovery/aggregated"
+	openapinamer "k8s.io/apiserver/pkg/endpoints/openapi"
+	"k8s.io/apiserver/pkg/endpoints/request"
+	"k8s.io/apiserver/pkg/registry/generic"
+	genericapiserver "k8s.io/apiserver/pkg/server"
+	"k8s.io/klog/v2"
+
+	"github.com/everoute/runtime/pkg/options"
+)
+
+// NewCustomResourceOptions serves custom resources defined by the CustomResourceDefinition
+// manifests in the directory, the resources are stored in etcd as unstructured objects, and
+// validated, defaulted and pruned by the openAPIV3Schema as the kube-apiserver does.
+//
+// The resources are served by an apiextensions-apiserver delegated by the apiserver, with the
+// same serving, authentication and etcd options. The definitions are created or updated when
+// the apiserver started, the resources are not published in the openapi of the apiserver. The
+// apiextensions api is only accessible by the apiserver itself, clients could not define more
+// custom resources at runtime.
+func NewCustomResourceOptions(defaultDir string) options.Options {
+	return &customResourceOptions{Dir: defaultDir}
+}
+
+type customResourceOptions struct {
+	Dir string
+}
+
+func (o *customResourceOptions) AddFlags(flagSet *pflag.FlagSet) {
+	flagSet.StringVar(&o.Dir, "custom-resource-dir", o.Dir, "directory of CustomResourceDefinition manifests (yaml or json) to serve, disabled if empty")
+}
+
+func (o *customResourceOptions) Validate() []error {
+	if o.Dir == "" {
+		return nil
+	}
+	if _, err := LoadCustomResourceDefinitions(o.Dir); err != nil {
+		return []error{fmt.Errorf("invalid --custom-resource-dir: %w", err)}
+	}
+	return nil
+}
+
+func (o *customResourceOptions) ApplyTo(config *options.RecommendedConfig) error {
+	if o.Dir == "" {
+		return nil
+	}
+	crds, err := LoadCustomResourceDefinitions(o.Dir)
+	if err != nil {
+		return err
+	}
+
+	// share the aggregated discovery, so that custom resources could be discovered from the apiserver
+	if config.AggregatedDiscoveryGroupManager == nil {
+		config.AggregatedDiscoveryGroupManager = discoveryendpoint.NewResourceManager("apis")
+	}
+	config.AddDelegate(func(delegate genericapiserver.DelegationTarget) (genericapiserver.DelegationTarget, error) {
+		return newCustomResourceServer(config, crds, delegate)
+	})
+	return nil
+}
+
+// LoadCustomResourceDefinitions reads and validates CustomResourceDefinitions from
+// yaml or json files in the directory, a file may contain multiple documents.
+func LoadCustomResourceDefinitions(dir string) ([]*apiextensionsv1.CustomResourceDefinition, error) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	var crds []*apiextensionsv1.CustomResourceDefinition
+	for _, entry := range entries {
+		if entry.IsDir() || !sets.New(".yaml", ".yml", ".json").Has(filepath.Ext(entry.Name())) {
+			continue
+		}
+		fileCRDs, err := decodeCustomResourceDefinitions(filepath.Join(dir, entry.Name()))
+		if err != nil {
+			return nil, fmt.Errorf("load %s: %w", entry.Name(), err)
+		}
+		crds = append(crds, fileCRDs...)
+	}
+	return crds, nil
+}
+
+func decodeCustomResourceDefinitions(path string) ([]*apiextensionsv1.CustomResourceDefinition, error) {
+	raw, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var crds []*apiextensionsv1.CustomResourceDefinition
+	decoder := utilyaml.NewYAMLOrJSONDecoder(bytes.NewReader(raw), 4096)
+	for {
+		crd := &apiextensionsv1.CustomResourceDefinition{}
+		if err := decoder.Decode(crd); err != nil {
+			if errors.Is(err, io.EOF) {
+				return crds, nil
+			}
+			return nil, err
+		}
+		if crd.Name == "" && crd.Kind == "" {
+			continue // empty document
+		}
+		if gvk := crd.GroupVersionKind(); gvk != apiextensionsv1.SchemeGroupVersion.WithKind("CustomResourceDefinition") {
+			return nil, fmt.Errorf("unexpected kind %s", gvk)
+		}
+		if err := validateCustomResourceDefinition(crd); err != nil {
+			return nil, fmt.Errorf("invalid CustomResourceDefinition %s: %w", crd.Name, err)
+		}
+		crds = append(crds, crd)
+	}
+}
+
+func validateCustomResourceDefinition(crd *apiextensionsv1.CustomResourceDefinition) error {
+	versioned := crd.DeepCopy()
+	apiextensionsapiserver.Scheme.Default(versioned)
+	internal := &apiextensions.CustomResourceDefinition{}
+	if err := apiextensionsapiserver.Scheme.Convert(versioned, internal, nil); err != nil {
+		return err
+	}
+	return apiextensionsvalidation.ValidateCustomResourceDefinition(context.Background(), internal).ToAggregate()
+}
+
+// newCustomResourceServer creates an apiextensions-apiserver from the config, the
+// CustomResourceDefinitions are created or updated in the post-start hook
+func newCustomResourceServer(config *options.RecommendedConfig, crds []*apiextensionsv1.CustomResourceDefinition,
+	delegate genericapiserver.DelegationTarget) (genericapiserver.DelegationTarget, error) {
+	if config.RESTOptionsGetter == nil {
+		return nil, fmt.Errorf("missing RESTOptionsGetter, etcd options must be applied")
+	}
+
+	genericConfig := config.Config
+	genericConfig.PostStartHooks = map[string]genericapiserver.PostStartHookConfigEntry{}
+	genericConfig.MergedResourceConfig = apiextensionsapiserver.DefaultAPIResourceConfigSource()
+	genericConfig.RESTOptionsGetter = &codecRESTOptionsGetter{
+		RESTOptionsGetter: config.RESTOptionsGetter,
+		codec:             apiextensionsapiserver.Codecs.LegacyCodec(v1beta1.SchemeGroupVersion, apiextensionsv1.SchemeGroupVersion),
+	}
+	// openapi of custom resources is not served, definitions only used to build models
+	genericConfig.OpenAPIConfig = nil
+	genericConfig.OpenAPIV3Config = genericapiserver.DefaultOpenAPIV3Config(apiextensionsopenapi.GetOpenAPIDefinitions,
+		openapinamer.NewDefinitionNamer(apiextensionsapiserver.Scheme))
+	genericConfig.SkipOpenAPIInstallation = true
+
+	apiextensionsConfig := &apiextensionsapiserver.Config{
+		GenericConfig: &genericapiserver.RecommendedConfig{
+			Config:                genericConfig,
+			SharedInformerFactory: config.SharedInformerFactory,
+		},
+		ExtraConfig: apiextensionsapiserver.ExtraConfig{
+			CRDRESTOptionsGetter: &codecRESTOptionsGetter{
+				RESTOptionsGetter: config.RESTOptionsGetter,
+				codec:             unstructured.UnstructuredJSONScheme,
+			},
+			MasterCount: 1,
+		},
+	}
+	server, err := apiextensionsConfig.Complete().New(delegate)
+	if err != nil {
+		return nil, err
+	}
+
+	err = server.GenericAPIServer.AddPostStartHook("custom-resource-definitions-loader", func(context genericapiserver.PostStartHookContext) error {
+		client, err := apiextensionsclientset.NewForConfig(context.LoopbackClientConfig)
+		if err != nil {
+			return err
+		}
+		return ensureCustomResourceDefinitions(wait.ContextForChannel(context.StopCh), client, crds)
+	})
+	if config.AggregatedDiscoveryGroupManager != nil {
+		config.AggregatedDiscoveryGroupManager.RemoveGroup(apiextensions.GroupName)
+	}
+	return &customResourceDelegate{
+		DelegationTarget: server.GenericAPIServer,
+		loopbackToken:    lo.FromPtr(config.LoopbackClientConfig).BearerToken,
+	}, err
+}
+
+// apiextensionsPath is the path prefix of the apiextensions api
+var apiextensionsPath = "/apis/" + apiextensions.GroupName
+
+// customResourceDelegate hides the apiextensions api from clients except the apiserver itself,
+// which watches and loads the CustomResourceDefinitions through the loopback client.
+type customResourceDelegate struct {
+	genericapiserver.DelegationTarget
+	loopbackToken string
+}
+
+func (d *customResourceDelegate) UnprotectedHandler() http.Handler {
+	handler := d.DelegationTarget.UnprotectedHandler()
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if isAPIExtensionsPath(req.URL.Path) && !d.isLoopback(req) {
+			http.NotFound(w, req)
+			return
+		}
+		handler.ServeHTTP(w, req)
+	})
+}
+
+// isLoopback returns true if the request from the loopback client, the token is kept in the
+// header when authentication disabled, otherwise it's authenticated as the apiserver user.
+func (d *customResourceDelegate) isLoopback(req *http.Request) bool {
+	if u, ok := request.UserFrom(req.Context()); ok && u.GetName() == user.APIServerUser {
+		return true
+	}
+	token, found := strings.CutPrefix(req.Header.Get("Authorization"), "Bearer ")
+	return found && d.loopbackToken != "" && subtle.ConstantTimeCompare([]byte(token), []byte(d.loopbackToken)) == 1
+}
+
+func (d *customResourceDelegate) ListedPaths() []string {
+	return lo.Reject(d.DelegationTarget.ListedPaths(), func(path string, _ int) bool { return isAPIExtensionsPath(path) })
+}
+
+func isAPIExtensionsPath(path string) bool {
+	return path == apiextensionsPath || strings.HasPrefix(path, apiextensionsPath+"/")
+}
+
+// ensureCustomResourceDefinitions creates or updates the definitions, and waits for them established
+func ensureCustomResourceDefinitions(ctx context.Context, client apiextensionsclientset.Interface, crds []*apiextensionsv1.CustomResourceDefinition) error {
+	crdClient := client.ApiextensionsV1().CustomResourceDefinitions()
+
+	for _, crd := range crds {
+		err := wait.PollUntilContextCancel(ctx, time.Second, true, func(ctx context.Context) (bool, error) {
+			existing, err := crdClient.Get(ctx, crd.Name, metav1.GetOptions{})
+			switch {
+			case apierrors.IsNotFound(err):
+				_, err = crdClient.Create(ctx, crd, metav1.CreateOptions{})
+			case err == nil:
+				update := existing.DeepCopy()
+				update.Labels, update.Annotations, update.Spec = crd.Labels, crd.Annotations, crd.Spec
+				_, err = crdClient.Update(ctx, update, metav1.UpdateOptions{})
+			}
+			if err != nil {
+				klog.Errorf("unable to ensure CustomResourceDefinition %s: %s", crd.Name, err)
+				return false, nil
+			}
+			return true, nil
+		})
+		if err != nil {
+			return fmt.Errorf("ensure CustomResourceDefinition %s: %w", crd.Name, err)
+		}
+	}
+
+	for _, crd := range crds {
+		err := wait.PollUntilContextCancel(ctx, 100*time.Millisecond, true, func(ctx context.Context) (bool, error) {
+			existing, err := crdClient.Get(ctx, crd.Name, metav1.GetOptions{})
+			if err != nil {
+				return false, nil
+			}
+			for _, condition := range existing.Status.Conditions {
+				if condition.Type == apiextensionsv1.Established && condition.Status == apiextensionsv1.ConditionTrue {
+					return true, nil
+				}
+			}
+			return false, nil
+		})
+		if err != nil {
+			return fmt.Errorf("wait CustomResourceDefinition %s established: %w", crd.Name, err)
+		}
+		klog.Infof("CustomResourceDefinition %s has been established", crd.Name)
+	}
+	return nil
+}
+
+// codecRESTOptionsGetter overrides the storage codec of the RESTOptionsGetter
+type codecRESTOptionsGetter struct {
+	generic.RESTOptionsGetter
+	codec runtime.Codec
+}
+
+func (g *codecRESTOptionsGetter) GetRESTOptions(resource schema.GroupResource) (generic.RESTOptions, error) {
+	restOptions, err := g.RESTOptionsGetter.GetRESTOptions(resource)
+	if err != nil {
+		return restOptions, err
+	}
+	storageConfig := *restOptions.StorageConfig
+	storageConfig.Codec = g.codec
+	restOptions.StorageConfig = &storageConfig
+	return restOptions, nil
+}
@@ -0,0 +1,140 @@
+package registry_test
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+
+	. "github.com/onsi/gomega"
+	apiextensionsv1 "k8s.io/apiextensions-apiserver/pkg/apis/apiextensions/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/client-go/discovery"
+	"k8s.io/client-go/dynamic"
+	"k8s.io/client-go/kubernetes/scheme"
+
+	"github.com/everoute/runtime/pkg/app"
+	"github.com/everoute/runtime/pkg/options"
+	"github.com/everoute/runtime/pkg/registry"
+	. "github.com/everoute/runtime/pkg/util/testing"
+)
+
+const barDefinition = `
+apiVersion: apiextensions.k8s.io/v1
+kind: CustomResourceDefinition
+metadata:
+  name: bars.crd.unittest.everoute.io
+spec:
+  group: crd.unittest.everoute.io
+  names:
+    kind: Bar
+    listKind: BarList
+    plural: bars
+    singular: bar
+  scope: Namespaced
+  versions:
+  - name: v1alpha1
+    served: true
+    storage: true
+    subresources:
+      status: {}
+    schema:
+      openAPIV3Schema:
+        type: object
+        properties:
+          spec:
+            type: object
+            properties:
+              replicas:
+                type: integer
+                minimum: 0
+                default: 1
+          status:
+            type: object
+            x-kubernetes-preserve-unknown-fields: true
+---
+`
+
+var barGroupVersion = schema.GroupVersion{Group: "crd.unittest.everoute.io", Version: "v1alpha1"}
+
+func TestCustomResourceOptions(t *testing.T) {
+	RegisterTestingT(t)
+
+	dir := t.TempDir()
+	Expect(os.WriteFile(filepath.Join(dir, "bars.yaml"), []byte(barDefinition), 0600)).ShouldNot(HaveOccurred())
+	Expect(os.WriteFile(filepath.Join(dir, "README.md"), []byte("ignored"), 0600)).ShouldNot(HaveOccurred())
+
+	cmd := app.NewCommand(app.CommandConfig{
+		Name:         "runtime-apiserver-unittest",
+		Codecs:       scheme.Codecs,
+		EtcdPrefix:   "/everoute/unittest",
+		StorageCodec: scheme.Codecs.LegacyCodec(metav1.SchemeGroupVersion),
+		Options:      []options.Options{registry.NewCustomResourceOptions(dir)},
+	})
+	config := NewTestingClient(t, cmd)
+
+	ctx := context.Background()
+	client := dynamic.NewForConfigOrDie(config).Resource(barGroupVersion.WithResource("bars")).Namespace(metav1.NamespaceDefault)
+	newBar := func(name string, spec map[string]interface{}) *unstructured.Unstructured {
+		return &unstructured.Unstructured{Object: map[string]interface{}{
+			"apiVersion": barGroupVersion.String(),
+			"kind":       "Bar",
+			"metadata":   map[string]interface{}{"name": name},
+			"spec":       spec,
+		}}
+	}
+
+	t.Run("should default and prune the resource", func(t *testing.T) {
+		var bar *unstructured.Unstructured
+		Eventually(func() (err error) {
+			bar, err = client.Create(ctx, newBar("default", map[string]interface{}{"unknown": "foo"}), metav1.CreateOptions{})
+			return err
+		}).ShouldNot(HaveOccurred())
+		Expect(bar.Object["spec"]).Should(Equal(map[string]interface{}{"replicas": int64(1)}))
+	})
+
+	t.Run("should validate the resource", func(t *testing.T) {
+		_, err := client.Create(ctx, newBar("invalid", map[string]interface{}{"replicas": int64(-1)}), metav1.CreateOptions{})
+		Expect(err).Should(MatchError(ContainSubstring("spec.replicas")))
+	})
+
+	t.Run("should discover the resource", func(t *testing.T) {
+		resources, err := discovery.NewDiscoveryClientForConfigOrDie(config).ServerResourcesForGroupVersion(barGroupVersion.String())
+		Expect(err).ShouldNot(HaveOccurred())
+		Expect(resources.APIResources).Should(ContainElement(HaveField("Name", "bars")))
+	})
+
+	t.Run("should not serve the apiextensions api", func(t *testing.T) {
+		crds := dynamic.NewForConfigOrDie(config).Resource(apiextensionsv1.SchemeGroupVersion.WithResource("customresourcedefinitions"))
+		_, err := crds.Create(ctx, &unstructured.Unstructured{Object: map[string]interface{}{
+			"apiVersion": apiextensionsv1.SchemeGroupVersion.String(),
+			"kind":       "CustomResourceDefinition",
+			"metadata":   map[string]interface{}{"name": "bazs." + barGroupVersion.Group},
+		}}, metav1.CreateOptions{})
+		Expect(apierrors.IsNotFound(err)).Should(BeTrue(), "unexpected error %v", err)
+		_, err = crds.List(ctx, metav1.ListOptions{})
+		Expect(apierrors.IsNotFound(err)).Should(BeTrue(), "unexpected error %v", err)
+
+		groups, err := discovery.NewDiscoveryClientForConfigOrDie(config).ServerGroups()
+		Expect(err).ShouldNot(HaveOccurred())
+		Expect(groups.Groups).ShouldNot(ContainElement(HaveField("Name", apiextensionsv1.GroupName)))
+	})
+}
+
+func TestLoadCustomResourceDefinitions(t *testing.T) {
+	RegisterTestingT(t)
+
+	dir := t.TempDir()
+	Expect(os.WriteFile(filepath.Join(dir, "bars.yaml"), []byte(barDefinition), 0600)).ShouldNot(HaveOccurred())
+	crds, err := registry.LoadCustomResourceDefinitions(dir)
+	Expect(err).ShouldNot(HaveOccurred())
+	Expect(crds).Should(HaveLen(1))
+	Expect(crds[0].Spec.Names.Kind).Should(Equal("Bar"))
+
+	Expect(os.WriteFile(filepath.Join(dir, "invalid.yaml"), []byte("apiVersion: apiextensions.k8s.io/v1\nkind: CustomResourceDefinition\nmetadata:\n  name: invalid\n"), 0600)).ShouldNot(HaveOccurred())
+	_, err = registry.LoadCustomResourceDefinitions(dir)
+	Expect(err).Should(MatchError(ContainSubstring("invalid CustomResourceDefinition invalid")))
+}
@@ -0,0 +1,151 @@
+package registry
+
+import (
+	"fmt"
+	"strings"
+
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/runtime/serializer"
+	"k8s.io/apimachinery/pkg/util/sets"
+	"k8s.io/apiserver/pkg/registry/generic"
+	genericregistry "k8s.io/apiserver/pkg/registry/generic/registry"
+	"k8s.io/apiserver/pkg/registry/rest"
+	genericapiserver "k8s.io/apiserver/pkg/server"
+
+	"github.com/everoute/runtime/pkg/options"
+)
+
+// APIGroup declares a group version and its resources served by the apiserver.
+// Types of the resources must be registered into the Scheme, both the group
+// version and the internal version.
+type APIGroup struct {
+	GroupVersion   schema.GroupVersion
+	Scheme         *runtime.Scheme
+	Codecs         serializer.CodecFactory
+	ParameterCodec runtime.ParameterCodec
+	Resources      []Resource
+}
+
+// Resource declares a resource stored in etcd
+type Resource struct {
+	// Name is the plural name of the resource, e.g. foos
+	Name string
+	// SingularName defaults to the lowercase kind
+	SingularName    string
+	NamespaceScoped bool
+
+	New     func() runtime.Object
+	NewList func() runtime.Object
+
+	// Strategy hooks the create and update of the resource, all fields are optional
+	Strategy Strategy
+	// StatusEnabled enables the status subresource, the type must have a field named Status.
+	// The status is ignored when create or update the resource, and only the status could be
+	// updated through the status subresource.
+	StatusEnabled bool
+	// TableColumns are the additional columns printed after the name
+	TableColumns []TableColumn
+}
+
+// Install builds stores of the resources from the RESTOptionsGetter, and installs the
+// group into the apiserver. It could be used as the app.APIGroupInstaller, the OpenAPIV3Config
+// is required, e.g. set by the OpenAPIOptions.
+func (g *APIGroup) Install(apiserver *genericapiserver.GenericAPIServer, config *options.RecommendedConfig) error {
+	if config.RESTOptionsGetter == nil {
+		return fmt.Errorf("missing RESTOptionsGetter, etcd options must be applied")
+	}
+	if config.OpenAPIV3Config == nil {
+		return fmt.Errorf("missing OpenAPIV3Config, it's required to build models for the server side apply, apply the OpenAPIOptions first")
+	}
+
+	storage, err := g.NewStorage(config.RESTOptionsGetter)
+	if err != nil {
+		return err
+	}
+	apiGroupInfo := genericapiserver.NewDefaultAPIGroupInfo(g.GroupVersion.Group, g.Scheme, g.ParameterCodec, g.Codecs)
+	apiGroupInfo.VersionedResourcesStorageMap[g.GroupVersion.Version] = storage
+	return apiserver.InstallAPIGroup(&apiGroupInfo)
+}
+
+// NewStorage returns the storage of the resources keyed by the resource path, e.g. foos and foos/status
+func (g *APIGroup) NewStorage(getter generic.RESTOptionsGetter) (map[string]rest.Storage, error) {
+	if err := g.validate(); err != nil {
+		return nil, err
+	}
+
+	storage := make(map[string]rest.Storage, len(g.Resources))
+	for _, resource := range g.Resources {
+		store, err := g.newStore(resource, getter)
+		if err != nil {
+			return nil, fmt.Errorf("build store of resource %s: %w", resource.Name, err)
+		}
+		storage[resource.Name] = store
+		if resource.StatusEnabled {
+			storage[resource.Name+"/status"] = newStatusREST(store)
+		}
+	}
+	return storage, nil
+}
+
+// OpenAPIOptions configures the openapi with types registered in the group scheme, which is
+// required by Install. It's skipped if the openapi has been configured, e.g. by the command
+// with the Scheme.
+func (g *APIGroup) OpenAPIOptions(title string) options.Options {
+	return &openAPIOptions{Options: options.NewOpenAPIOptions(title, nil, g.Scheme)}
+}
+
+type openAPIOptions struct {
+	options.Options
+}
+
+func (o *openAPIOptions) ApplyTo(config *options.RecommendedConfig) error {
+	if config.OpenAPIV3Config != nil {
+		return nil
+	}
+	return o.Options.ApplyTo(config)
+}
+
+func (g *APIGroup) validate() error {
+	names := sets.New[string]()
+	for _, resource := range g.Resources {
+		if resource.Name == "" || resource.New == nil || resource.NewList == nil {
+			return fmt.Errorf("name, new and new list func of resource are required")
+		}
+		if names.Has(resource.Name) {
+			return fmt.Errorf("resource %s has been declared", resource.Name)
+		}
+		names.Insert(resource.Name)
+		if _, _, err := g.Scheme.ObjectKinds(resource.New()); err != nil {
+			return err
+		}
+		if resource.StatusEnabled && !hasStatus(resource.New()) {
+			return fmt.Errorf("resource %s enabled status but has no status field", resource.Name)
+		}
+	}
+	return nil
+}
+
+func (g *APIGroup) newStore(resource Resource, getter generic.RESTOptionsGetter) (*genericregistry.Store, error) {
+	if resource.SingularName == "" {
+		kinds, _, _ := g.Scheme.ObjectKinds(resource.New())
+		resource.SingularName = strings.ToLower(kinds[0].Kind)
+	}
+
+	groupResource := g.GroupVersion.WithResource(resource.Name).GroupResource()
+	strategy := newStrategy(g.Scheme, resource)
+	store := &genericregistry.Store{
+		NewFunc:                   resource.New,
+		NewListFunc:               resource.NewList,
+		DefaultQualifiedResource:  groupResource,
+		SingularQualifiedResource: g.GroupVersion.WithResource(resource.SingularName).GroupResource(),
+		CreateStrategy:            strategy,
+		UpdateStrategy:            strategy,
+		DeleteStrategy:            strategy,
+		TableConvertor:            newTableConvertor(groupResource, resource.TableColumns),
+	}
+	if err := store.CompleteWithOptions(&generic.StoreOptions{RESTOptions: getter}); err != nil {
+		return nil, err
+	}
+	return store, nil
+}
@@ -0,0 +1,221 @@
+package registry_test
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+
+	. "github.com/onsi/gomega"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/runtime/serializer"
+	"k8s.io/apimachinery/pkg/util/validation/field"
+	"k8s.io/client-go/dynamic"
+	"k8s.io/client-go/kubernetes"
+
+	"github.com/everoute/runtime/pkg/app"
+	"github.com/everoute/runtime/pkg/options"
+	"github.com/everoute/runtime/pkg/registry"
+	. "github.com/everoute/runtime/pkg/util/testing"
+)
+
+type Foo struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+	Spec              FooSpec   `json:"spec,omitempty"`
+	Status            FooStatus `json:"status,omitempty"`
+}
+
+type FooSpec struct {
+	Replicas int64 `json:"replicas"`
+}
+
+type FooStatus struct {
+	Ready int64 `json:"ready"`
+}
+
+func (in *Foo) DeepCopyObject() runtime.Object {
+	out := *in
+	in.ObjectMeta.DeepCopyInto(&out.ObjectMeta)
+	return &out
+}
+
+type FooList struct {
+	metav1.TypeMeta `json:",inline"`
+	metav1.ListMeta `json:"metadata,omitempty"`
+	Items           []Foo `json:"items"`
+}
+
+func (in *FooList) DeepCopyObject() runtime.Object {
+	out := *in
+	out.Items = make([]Foo, 0, len(in.Items))
+	for i := range in.Items {
+		out.Items = append(out.Items, *in.Items[i].DeepCopyObject().(*Foo))
+	}
+	return &out
+}
+
+var fooGroupVersion = schema.GroupVersion{Group: "unittest.everoute.io", Version: "v1alpha1"}
+
+func newFooGroup() *registry.APIGroup {
+	scheme := runtime.NewScheme()
+	scheme.AddKnownTypes(fooGroupVersion, &Foo{}, &FooList{})
+	scheme.AddKnownTypes(schema.GroupVersion{Group: fooGroupVersion.Group, Version: runtime.APIVersionInternal}, &Foo{}, &FooList{})
+	metav1.AddToGroupVersion(scheme, fooGroupVersion)
+	metav1.AddToGroupVersion(scheme, schema.GroupVersion{Version: "v1"})
+	scheme.AddUnversionedTypes(schema.GroupVersion{Version: "v1"},
+		&metav1.Status{}, &metav1.APIVersions{}, &metav1.APIGroupList{}, &metav1.APIGroup{}, &metav1.APIResourceList{})
+
+	return &registry.APIGroup{
+		GroupVersion:   fooGroupVersion,
+		Scheme:         scheme,
+		Codecs:         serializer.NewCodecFactory(scheme),
+		ParameterCodec: runtime.NewParameterCodec(scheme),
+		Resources: []registry.Resource{{
+			Name:            "foos",
+			NamespaceScoped: true,
+			New:             func() runtime.Object { return &Foo{} },
+			NewList:         func() runtime.Object { return &FooList{} },
+			Strategy: registry.Strategy{
+				Validate: func(_ context.Context, obj runtime.Object) field.ErrorList {
+					if replicas := obj.(*Foo).Spec.Replicas; replicas < 0 {
+						return field.ErrorList{field.Invalid(field.NewPath("spec", "replicas"), replicas, "must not be negative")}
+					}
+					return nil
+				},
+			},
+			StatusEnabled: true,
+			TableColumns: []registry.TableColumn{{
+				TableColumnDefinition: metav1.TableColumnDefinition{Name: "Replicas", Type: "integer"},
+				Value:                 func(obj runtime.Object) interface{} { return obj.(*Foo).Spec.Replicas },
+			}},
+		}},
+	}
+}
+
+func TestAPIGroupInstall(t *testing.T) {
+	RegisterTestingT(t)
+
+	group := newFooGroup()
+	cmd := app.NewCommand(app.CommandConfig{
+		Name:         "runtime-apiserver-unittest",
+		Codecs:       group.Codecs,
+		EtcdPrefix:   "/everoute/unittest",
+		StorageCodec: group.Codecs.LegacyCodec(fooGroupVersion),
+		Scheme:       group.Scheme,
+		APIGroups:    []app.APIGroupInstaller{group.Install},
+	})
+	config := NewTestingClient(t, cmd)
+
+	ctx := context.Background()
+	client := dynamic.NewForConfigOrDie(config).Resource(fooGroupVersion.WithResource("foos")).Namespace(metav1.NamespaceDefault)
+	newFoo := func(replicas, ready int64) *unstructured.Unstructured {
+		return &unstructured.Unstructured{Object: map[string]interface{}{
+			"apiVersion": fooGroupVersion.String(),
+			"kind":       "Foo",
+			"metadata":   map[string]interface{}{"name": "foo"},
+			"spec":       map[string]interface{}{"replicas": replicas},
+			"status":     map[string]interface{}{"ready": ready},
+		}}
+	}
+	nestedInt64 := func(obj *unstructured.Unstructured, fields ...string) int64 {
+		v, _, _ := unstructured.NestedInt64(obj.Object, fields...)
+		return v
+	}
+
+	t.Run("should validate the resource", func(t *testing.T) {
+		_, err := client.Create(ctx, newFoo(-1, 0), metav1.CreateOptions{})
+		Expect(err).Should(MatchError(ContainSubstring("must not be negative")))
+	})
+
+	t.Run("should ignore status when create or update resource", func(t *testing.T) {
+		foo, err := client.Create(ctx, newFoo(1, 1), metav1.CreateOptions{})
+		Expect(err).ShouldNot(HaveOccurred())
+		Expect(nestedInt64(foo, "spec", "replicas")).Should(Equal(int64(1)))
+		Expect(nestedInt64(foo, "status", "ready")).Should(BeZero())
+
+		update := newFoo(2, 2)
+		update.SetResourceVersion(foo.GetResourceVersion())
+		foo, err = client.Update(ctx, update, metav1.UpdateOptions{})
+		Expect(err).ShouldNot(HaveOccurred())
+		Expect(nestedInt64(foo, "spec", "replicas")).Should(Equal(int64(2)))
+		Expect(nestedInt64(foo, "status", "ready")).Should(BeZero())
+	})
+
+	t.Run("should only update status through status subresource", func(t *testing.T) {
+		foo, err := client.Get(ctx, "foo", metav1.GetOptions{})
+		Expect(err).ShouldNot(HaveOccurred())
+
+		update := newFoo(3, 3)
+		update.SetResourceVersion(foo.GetResourceVersion())
+		update.SetLabels(map[string]string{"foo": "bar"})
+		foo, err = client.UpdateStatus(ctx, update, metav1.UpdateOptions{})
+		Expect(err).ShouldNot(HaveOccurred())
+		Expect(nestedInt64(foo, "spec", "replicas")).Should(Equal(int64(2)))
+		Expect(nestedInt64(foo, "status", "ready")).Should(Equal(int64(3)))
+		Expect(foo.GetLabels()).Should(BeEmpty())
+	})
+
+	t.Run("should serve openapi of the resource", func(t *testing.T) {
+		raw, err := kubernetes.NewForConfigOrDie(config).Discovery().RESTClient().Get().AbsPath("/openapi/v2").DoRaw(ctx)
+		Expect(err).ShouldNot(HaveOccurred())
+		Expect(string(raw)).Should(ContainSubstring(`"/apis/unittest.everoute.io/v1alpha1/namespaces/{namespace}/foos/{name}/status"`))
+	})
+
+	t.Run("should print table with additional columns", func(t *testing.T) {
+		raw, err := kubernetes.NewForConfigOrDie(config).Discovery().RESTClient().Get().
+			AbsPath("/apis", fooGroupVersion.Group, fooGroupVersion.Version, "namespaces", metav1.NamespaceDefault, "foos").
+			SetHeader("Accept", "application/json;as=Table;v=v1;g=meta.k8s.io").DoRaw(ctx)
+		Expect(err).ShouldNot(HaveOccurred())
+
+		var table metav1.Table
+		Expect(json.Unmarshal(raw, &table)).ShouldNot(HaveOccurred())
+		Expect(table.ColumnDefinitions).Should(HaveLen(3))
+		Expect(table.ColumnDefinitions[1].Name).Should(Equal("Replicas"))
+		Expect(table.Rows).Should(HaveLen(1))
+		Expect(table.Rows[0].Cells[:2]).Should(Equal([]interface{}{"foo", float64(2)}))
+	})
+}
+
+func TestAPIGroupInstallWithOpenAPIOptions(t *testing.T) {
+	RegisterTestingT(t)
+
+	group := newFooGroup()
+	cmd := app.NewCommand(app.CommandConfig{
+		Name:         "runtime-apiserver-unittest",
+		Codecs:       group.Codecs,
+		EtcdPrefix:   "/everoute/unittest",
+		StorageCodec: group.Codecs.LegacyCodec(fooGroupVersion),
+		APIGroups:    []app.APIGroupInstaller{group.Install},
+		Options:      []options.Options{group.OpenAPIOptions("runtime-apiserver-unittest")},
+	})
+	config := NewTestingClient(t, cmd)
+
+	client := dynamic.NewForConfigOrDie(config).Resource(fooGroupVersion.WithResource("foos")).Namespace(metav1.NamespaceDefault)
+	_, err := client.Apply(context.Background(), "foo", &unstructured.Unstructured{Object: map[string]interface{}{
+		"apiVersion": fooGroupVersion.String(),
+		"kind":       "Foo",
+		"metadata":   map[string]interface{}{"name": "foo"},
+		"spec":       map[string]interface{}{"replicas": int64(1)},
+	}}, metav1.ApplyOptions{FieldManager: "unittest"})
+	Expect(err).ShouldNot(HaveOccurred())
+}
+
+func TestAPIGroupNewStorage(t *testing.T) {
+	RegisterTestingT(t)
+
+	group := newFooGroup()
+	group.Resources = append(group.Resources, group.Resources[0])
+	_, err := group.NewStorage(nil)
+	Expect(err).Should(MatchError(ContainSubstring("has been declared")))
+
+	group = newFooGroup()
+	group.Resources[0].New = func() runtime.Object { return &FooList{} }
+	_, err = group.NewStorage(nil)
+	Expect(err).Should(MatchError(ContainSubstring("has no status field")))
+
+	_, err = newFooGroup().NewStorage(nil)
+	Expect(err).Should(MatchError(ContainSubstring("must have RESTOptions set")))
+}
@@ -0,0 +1,42 @@
+package registry
+
+import (
+	"context"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	genericregistry "k8s.io/apiserver/pkg/registry/generic/registry"
+	"k8s.io/apiserver/pkg/registry/rest"
+)
+
+// statusREST implements the status subresource, it shares the storage with the resource
+type statusREST struct {
+	store *genericregistry.Store
+}
+
+func newStatusREST(store *genericregistry.Store) *statusREST {
+	statusStore := *store
+	statusStore.UpdateStrategy = statusStrategy{strategy: store.UpdateStrategy.(*strategy)}
+	return &statusREST{store: &statusStore}
+}
+
+func (r *statusREST) New() runtime.Object {
+	return r.store.New()
+}
+
+// Destroy does nothing, the storage would be destroyed by the resource store
+func (r *statusREST) Destroy() {}
+
+func (r *statusREST) Get(ctx context.Context, name string, options *metav1.GetOptions) (runtime.Object, error) {
+	return r.store.Get(ctx, name, options)
+}
+
+func (r *statusREST) Update(ctx context.Context, name string, objInfo rest.UpdatedObjectInfo, createValidation rest.ValidateObjectFunc,
+	updateValidation rest.ValidateObjectUpdateFunc, _ bool, options *metav1.UpdateOptions) (runtime.Object, bool, error) {
+	// subresources should never allow create on update
+	return r.store.Update(ctx, name, objInfo, createValidation, updateValidation, false, options)
+}
+
+func (r *statusREST) ConvertToTable(ctx context.Context, object runtime.Object, tableOptions runtime.Object) (*metav1.Table, error) {
+	return r.store.ConvertToTable(ctx, object, tableOptions)
+}
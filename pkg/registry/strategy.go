@@ -0,0 +1,123 @@
+package registry
+
+import (
+	"context"
+	"reflect"
+
+	"k8s.io/apimachinery/pkg/api/meta"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/util/validation/field"
+	"k8s.io/apiserver/pkg/storage/names"
+)
+
+// Strategy hooks the create and update of the resource, the object meta
+// has been validated by the registry
+type Strategy struct {
+	PrepareForCreate func(ctx context.Context, obj runtime.Object)
+	PrepareForUpdate func(ctx context.Context, obj, old runtime.Object)
+	Validate         func(ctx context.Context, obj runtime.Object) field.ErrorList
+	// ValidateUpdate defaults to Validate the new object
+	ValidateUpdate func(ctx context.Context, obj, old runtime.Object) field.ErrorList
+}
+
+type strategy struct {
+	runtime.ObjectTyper
+	names.NameGenerator
+	hooks           Strategy
+	namespaceScoped bool
+	statusEnabled   bool
+}
+
+func newStrategy(typer runtime.ObjectTyper, resource Resource) *strategy {
+	return &strategy{
+		ObjectTyper:     typer,
+		NameGenerator:   names.SimpleNameGenerator,
+		hooks:           resource.Strategy,
+		namespaceScoped: resource.NamespaceScoped,
+		statusEnabled:   resource.StatusEnabled,
+	}
+}
+
+func (s *strategy) NamespaceScoped() bool { return s.namespaceScoped }
+
+func (s *strategy) PrepareForCreate(ctx context.Context, obj runtime.Object) {
+	if s.statusEnabled {
+		status := reflect.ValueOf(obj).Elem().FieldByName("Status")
+		status.Set(reflect.Zero(status.Type()))
+	}
+	if s.hooks.PrepareForCreate != nil {
+		s.hooks.PrepareForCreate(ctx, obj)
+	}
+}
+
+func (s *strategy) PrepareForUpdate(ctx context.Context, obj, old runtime.Object) {
+	if s.statusEnabled {
+		copyFields(obj, old, func(name string) bool { return name == "Status" })
+	}
+	if s.hooks.PrepareForUpdate != nil {
+		s.hooks.PrepareForUpdate(ctx, obj, old)
+	}
+}
+
+func (s *strategy) Validate(ctx context.Context, obj runtime.Object) field.ErrorList {
+	if s.hooks.Validate != nil {
+		return s.hooks.Validate(ctx, obj)
+	}
+	return nil
+}
+
+func (s *strategy) ValidateUpdate(ctx context.Context, obj, old runtime.Object) field.ErrorList {
+	if s.hooks.ValidateUpdate != nil {
+		return s.hooks.ValidateUpdate(ctx, obj, old)
+	}
+	return s.Validate(ctx, obj)
+}
+
+func (s *strategy) WarningsOnCreate(context.Context, runtime.Object) []string { return nil }
+
+func (s *strategy) WarningsOnUpdate(context.Context, runtime.Object, runtime.Object) []string {
+	return nil
+}
+
+func (s *strategy) Canonicalize(runtime.Object)    {}
+func (s *strategy) AllowCreateOnUpdate() bool      { return false }
+func (s *strategy) AllowUnconditionalUpdate() bool { return true }
+
+// statusStrategy only allows the status updated, changes of the other
+// fields and the metadata labels, annotations, finalizers are ignored
+type statusStrategy struct {
+	*strategy
+}
+
+func (s statusStrategy) PrepareForUpdate(_ context.Context, obj, old runtime.Object) {
+	copyFields(obj, old, func(name string) bool { return name != "TypeMeta" && name != "ObjectMeta" && name != "Status" })
+
+	objAccessor, err1 := meta.Accessor(obj)
+	oldAccessor, err2 := meta.Accessor(old)
+	if err1 != nil || err2 != nil {
+		return
+	}
+	objAccessor.SetLabels(oldAccessor.GetLabels())
+	objAccessor.SetAnnotations(oldAccessor.GetAnnotations())
+	objAccessor.SetFinalizers(oldAccessor.GetFinalizers())
+	objAccessor.SetOwnerReferences(oldAccessor.GetOwnerReferences())
+}
+
+func (s statusStrategy) ValidateUpdate(context.Context, runtime.Object, runtime.Object) field.ErrorList {
+	return nil
+}
+
+func hasStatus(obj runtime.Object) bool {
+	v := reflect.ValueOf(obj)
+	return v.Kind() == reflect.Pointer && v.Elem().Kind() == reflect.Struct && v.Elem().FieldByName("Status").IsValid()
+}
+
+// copyFields copies the struct fields matched by the name from src to dst
+func copyFields(dst, src runtime.Object, match func(name string) bool) {
+	dstValue, srcValue := reflect.ValueOf(dst).Elem(), reflect.ValueOf(src).Elem()
+	for i := 0; i < dstValue.NumField(); i++ {
+		if match(dstValue.Type().Field(i).Name) {
+			dstValue.Field(i).Set(srcValue.Field(i))
+		}
+	}
+}
@@ -0,0 +1,85 @@
+package registry
+
+import (
+	"context"
+	"time"
+
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/util/duration"
+	"k8s.io/apiserver/pkg/registry/rest"
+)
+
+// TableColumn is a column printed in the table, e.g. kubectl get
+type TableColumn struct {
+	metav1.TableColumnDefinition
+	// Value returns the cell of the object in the column
+	Value func(obj runtime.Object) interface{}
+}
+
+var objectMetaSwaggerDoc = metav1.ObjectMeta{}.SwaggerDoc()
+
+func newTableConvertor(resource schema.GroupResource, columns []TableColumn) rest.TableConvertor {
+	if len(columns) == 0 {
+		return rest.NewDefaultTableConvertor(resource)
+	}
+	return &tableConvertor{columns: columns}
+}
+
+// tableConvertor prints the name, additional columns and the age of objects
+type tableConvertor struct {
+	columns []TableColumn
+}
+
+func (c *tableConvertor) ConvertToTable(_ context.Context, object runtime.Object, tableOptions runtime.Object) (*metav1.Table, error) {
+	var table metav1.Table
+	addRow := func(obj runtime.Object) error {
+		m, err := meta.Accessor(obj)
+		if err != nil {
+			return err
+		}
+		cells := make([]interface{}, 0, len(c.columns)+2)
+		cells = append(cells, m.GetName())
+		for _, column := range c.columns {
+			cells = append(cells, column.Value(obj))
+		}
+		cells = append(cells, humanAge(m.GetCreationTimestamp()))
+		table.Rows = append(table.Rows, metav1.TableRow{Cells: cells, Object: runtime.RawExtension{Object: obj}})
+		return nil
+	}
+
+	if meta.IsListType(object) {
+		if err := meta.EachListItem(object, addRow); err != nil {
+			return nil, err
+		}
+	} else if err := addRow(object); err != nil {
+		return nil, err
+	}
+
+	if m, err := meta.ListAccessor(object); err == nil {
+		table.ResourceVersion = m.GetResourceVersion()
+		table.Continue = m.GetContinue()
+		table.RemainingItemCount = m.GetRemainingItemCount()
+	}
+	if opt, ok := tableOptions.(*metav1.TableOptions); !ok || !opt.NoHeaders {
+		table.ColumnDefinitions = append(table.ColumnDefinitions, metav1.TableColumnDefinition{
+			Name: "Name", Type: "string", Format: "name", Description: objectMetaSwaggerDoc["name"],
+		})
+		for _, column := range c.columns {
+			table.ColumnDefinitions = append(table.ColumnDefinitions, column.TableColumnDefinition)
+		}
+		table.ColumnDefinitions = append(table.ColumnDefinitions, metav1.TableColumnDefinition{
+			Name: "Age", Type: "string", Description: objectMetaSwaggerDoc["creationTimestamp"],
+		})
+	}
+	return &table, nil
+}
+
+func humanAge(timestamp metav1.Time) string {
+	if timestamp.IsZero() {
+		return "<unknown>"
+	}
+	return duration.HumanDuration(time.Since(timestamp.Time))
+}
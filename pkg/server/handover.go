@@ -0,0 +1,65 @@
+package server
+
+import (
+	"context"
+	"net/http"
+	"time"
+
+	utilnet "k8s.io/apimachinery/pkg/util/net"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/klog/v2"
+
+	"github.com/everoute/runtime/pkg/options"
+)
+
+const (
+	handoverPollInterval   = time.Second
+	handoverRequestTimeout = 5 * time.Second
+)
+
+// waitLeaderReady polls the readyz of the new leader until it ready, bounded
+// by the LeaderHandoverTimeout, make sure api available before shutdown.
+func waitLeaderReady(ctx context.Context, config *options.RecommendedConfig, leader string) {
+	if config.LeaderHandoverTimeout <= 0 {
+		return
+	}
+	if err := pollLeaderReady(ctx, config, leader); err != nil {
+		klog.Errorf("wait for new leader %s ready: %s", leader, err)
+		return
+	}
+	klog.Infof("new leader %s is ready", leader)
+}
+
+func pollLeaderReady(ctx context.Context, config *options.RecommendedConfig, leader string) error {
+	endpoint, err := config.PeerEndpoint(leader)
+	if err != nil {
+		return err
+	}
+	tlsConfig, err := config.PeerTLSConfig()
+	if err != nil {
+		return err
+	}
+	readyzURL := endpoint.JoinPath("/readyz").String()
+	httpClient := &http.Client{
+		Transport: utilnet.SetTransportDefaults(&http.Transport{TLSClientConfig: tlsConfig}),
+		Timeout:   handoverRequestTimeout,
+	}
+	defer httpClient.CloseIdleConnections()
+
+	return wait.PollUntilContextTimeout(ctx, handoverPollInterval, config.LeaderHandoverTimeout, true, func(ctx context.Context) (bool, error) {
+		req, err := http.NewRequestWithContext(ctx, http.MethodGet, readyzURL, nil)
+		if err != nil {
+			return false, err
+		}
+		resp, err := httpClient.Do(req)
+		if err != nil {
+			klog.V(4).Infof("new leader %s not ready: %s", leader, err)
+			return false, nil
+		}
+		resp.Body.Close()
+		if resp.StatusCode != http.StatusOK {
+			klog.V(4).Infof("new leader %s not ready: readyz status %d", leader, resp.StatusCode)
+		}
+		return resp.StatusCode == http.StatusOK, nil
+	})
+}
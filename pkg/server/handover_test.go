@@ -0,0 +1,63 @@
+package server_test
+
+import (
+	"context"
+	"net/http"
+	"testing"
+	"time"
+
+	. "github.com/onsi/gomega"
+	"go.uber.org/atomic"
+	"k8s.io/apimachinery/pkg/util/rand"
+
+	"github.com/everoute/runtime/pkg/options"
+	"github.com/everoute/runtime/pkg/server"
+	. "github.com/everoute/runtime/pkg/util/testing"
+)
+
+func TestGracefulShutdownHandover(t *testing.T) {
+	RegisterTestingT(t)
+
+	var readyzCount atomic.Int32
+	var ready atomic.Bool
+	peer := NewPeerServer(t, http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if req.URL.Path == "/readyz" {
+			readyzCount.Inc()
+		}
+		if !ready.Load() {
+			w.WriteHeader(http.StatusInternalServerError)
+		}
+	}))
+	newConfig := func(timeout time.Duration) *options.RecommendedConfig {
+		electionClient := NewFakeLeaderElectionClient(rand.String(20))
+		electionClient.SetLeader(peer.Identity())
+		config := &options.RecommendedConfig{LeaderElectionClient: electionClient, LeaderHandoverTimeout: timeout}
+		config.SecureServing = peer.LocalSecureServing()
+		return config
+	}
+
+	t.Run("should wait for new leader ready before shutdown", func(t *testing.T) {
+		readyzCount.Store(0)
+		ready.Store(false)
+		s := newFakeServer(nil)
+		errCh := make(chan error, 1)
+		go func() { errCh <- server.GracefulShutdown(context.Background(), newConfig(time.Minute), s) }()
+
+		Eventually(readyzCount.Load, 5*time.Second).Should(BeNumerically(">=", 2))
+		Expect(s.stopped).ShouldNot(BeClosed())
+
+		ready.Store(true)
+		Eventually(errCh, 5*time.Second).Should(Receive(MatchError(ContainSubstring("becomes leader"))))
+		Expect(s.stopped).Should(BeClosed())
+	})
+
+	t.Run("should shutdown when wait for new leader ready timeout", func(t *testing.T) {
+		ready.Store(false)
+		s := newFakeServer(nil)
+		errCh := make(chan error, 1)
+		go func() { errCh <- server.GracefulShutdown(context.Background(), newConfig(1500*time.Millisecond), s) }()
+
+		Consistently(errCh, time.Second).ShouldNot(Receive())
+		Eventually(errCh, 5*time.Second).Should(Receive(MatchError(ContainSubstring("becomes leader"))))
+	})
+}
@@ -2,41 +2,203 @@ package server
 
 import (
 	"context"
+	"errors"
+	"fmt"
+	"time"
 
 	"k8s.io/klog/v2"
 
+	"github.com/everoute/runtime/pkg/lifecycle"
 	"github.com/everoute/runtime/pkg/options"
 )
 
+// exit codes for the shutdown reasons
+const (
+	ExitCodeContextDone   = 0
+	ExitCodeServerError   = 1
+	ExitCodeLeaderChanged = 3
+)
+
+// ShutdownReason describes why the apiserver has been shutdown
+type ShutdownReason struct {
+	ExitCode int
+	Message  string
+	// Leader is the new leader when shutdown for leader changed
+	Leader string
+}
+
+func (r *ShutdownReason) Error() string { return r.Message }
+
+// Server is a prepared apiserver which runs until the stopCh closed
+type Server interface {
+	Run(stopCh <-chan struct{}) error
+}
+
 // GracefulShutdown graceful shutdown apiserver when leader lost
 // make sure api should available before the apiserver shutdown
 // stopped until context done, or another node becomes leader ready
-func GracefulShutdown(ctx context.Context, config *options.RecommendedConfig) {
-	electionCh := make(chan string)
+//
+// When another node becomes leader, it waits for the readyz of the new leader ready
+// before shutdown, bounded by the LeaderHandoverTimeout.
+//
+// When another node becomes leader, the stopped-leading lifecycle hooks are called by the
+// election, and the apiserver only shutdown with the exit policy. With demote or restart-controllers policy, the
+// apiserver keeps serving until the context done.
+//
+// The apiserver shutdown in order: call pre-shutdown hooks, release the lease, mark not-ready,
+// wait the ShutdownDelayDuration for in-flight requests drained, stop informers and controllers,
+// flush audit, and call shutdown hooks. The lease is released while the controllers running, so
+// that they observe the release, e.g. clear the published targets. It returns nil when stopped
+// for context done, otherwise the ShutdownReason which could be used as the exit code by Exit.
+func GracefulShutdown(ctx context.Context, config *options.RecommendedConfig, server Server) error {
+	stopCh := make(chan struct{})
+	serverErrCh := make(chan error, 1)
+	go func() { serverErrCh <- server.Run(stopCh) }()
+
+	waitCtx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	var reason *ShutdownReason
+	select {
+	case reason = <-waitForShutdown(waitCtx, config):
+	case err := <-serverErrCh:
+		reason = &ShutdownReason{ExitCode: ExitCodeServerError, Message: fmt.Sprintf("stopped when server exited: %v", err)}
+		serverErrCh = nil
+	}
+	klog.Infof("graceful shutdown apiserver: %s", reason)
+	if reason.Leader != "" {
+		waitLeaderReady(ctx, config, reason.Leader)
+	}
+	_ = config.Lifecycle.Run(context.Background(), lifecycle.PhasePreShutdown)
+	releaseLease(config)
+
+	// the apiserver marks not-ready and drains in-flight requests when stopCh closed,
+	// informers and controllers started in post-start hooks stop after requests drained
+	close(stopCh)
+	if serverErrCh != nil {
+		if err := <-serverErrCh; err != nil {
+			klog.Errorf("apiserver exited with error: %s", err)
+		}
+	}
+	if config.SharedInformerFactory != nil {
+		config.SharedInformerFactory.Shutdown()
+	}
+	_ = config.Lifecycle.Run(context.Background(), lifecycle.PhaseShutdown)
+
+	klog.Infof("apiserver has been shutdown: %s", reason)
+	if reason.ExitCode == ExitCodeContextDone {
+		return nil
+	}
+	return reason
+}
+
+// Exit flushes logs and exits with the exit code of the shutdown reason
+func Exit(err error) {
+	klog.FlushAndExit(klog.ExitFlushTimeout, ExitCode(err))
+}
+
+// ExitCode returns the exit code for the error returned by GracefulShutdown
+func ExitCode(err error) int {
+	var reason *ShutdownReason
+	switch {
+	case err == nil:
+		return ExitCodeContextDone
+	case errors.As(err, &reason):
+		return reason.ExitCode
+	default:
+		return ExitCodeServerError
+	}
+}
+
+// waitForShutdown returns the reason until context done, or another node becomes leader
+// with the exit policy.
+func waitForShutdown(ctx context.Context, config *options.RecommendedConfig) <-chan *ShutdownReason {
+	reasonCh := make(chan *ShutdownReason, 1)
 
 	go func() {
-		defer close(electionCh)
-		electionClient := config.LeaderElectionClient
-		if electionClient == nil {
-			return
+		// With the exit policy the apiserver only serves on the leader, it shutdown once
+		// another node is leader. Otherwise the leading state starts from the real state,
+		// a follower never led does not handle the leader lost.
+		leading := config.LeaderLostPolicy != options.LeaderLostPolicyDemote &&
+			config.LeaderLostPolicy != options.LeaderLostPolicyRestartControllers
+		for {
+			ld := waitLeaderChanged(ctx, config.LeaderElectionClient, leading)
+			if ld == "" {
+				break
+			}
+			if err := handleLeaderLost(config, ld); err != nil {
+				reasonCh <- &ShutdownReason{
+					ExitCode: ExitCodeLeaderChanged,
+					Message:  fmt.Sprintf("stopped when node %s becomes leader: %s", ld, err),
+					Leader:   ld,
+				}
+				return
+			}
+			if config.LeaderLostPolicy == options.LeaderLostPolicyDemote {
+				break
+			}
+			// with restart-controllers policy, wait for leading again
+			leading = false
 		}
+		<-ctx.Done()
+		reasonCh <- &ShutdownReason{ExitCode: ExitCodeContextDone, Message: fmt.Sprintf("stopped when context done: %s", ctx.Err())}
+	}()
+
+	return reasonCh
+}
+
+// waitLeaderChanged blocks until another node becomes leader, returns the new leader,
+// or returns empty when the context done. If not leading, it waits for the current
+// node becomes leader first.
+func waitLeaderChanged(ctx context.Context, electionClient options.LeaderElectionClient, leading bool) string {
+	if electionClient == nil {
+		return ""
+	}
+	for {
 		// check if leader has been changed
-		if ld := electionClient.GetLeader(); ld != "" && ld != electionClient.Identity() {
-			electionCh <- ld
-			return
+		switch ld := electionClient.GetLeader(); {
+		case ld == electionClient.Identity():
+			leading = true
+		case ld != "" && leading:
+			return ld
 		}
 		// until leading state update
-		for electionClient.UntilLeadingStateUpdate(ctx.Done()) {
-			if ld := electionClient.GetLeader(); ld != "" && ld != electionClient.Identity() {
-				electionCh <- ld
-			}
+		if !electionClient.UntilLeadingStateUpdate(ctx.Done()) {
+			return ""
 		}
-	}()
+	}
+}
 
-	select {
-	case <-ctx.Done():
-		klog.Fatalf("stopped when context done: %s", ctx.Err())
-	case ld := <-electionCh:
-		klog.Fatalf("stopped when node %s becomes leader", ld)
+// handleLeaderLost handles by the policy, returns error if the apiserver should exit
+func handleLeaderLost(config *options.RecommendedConfig, leader string) error {
+	policy := config.LeaderLostPolicy
+	klog.Infof("node %s becomes leader, handle with policy %s", leader, policy)
+
+	switch policy {
+	case options.LeaderLostPolicyDemote:
+		// no longer participates in election
+		releaseLease(config)
+		return nil
+	case options.LeaderLostPolicyRestartControllers:
+		return nil
+	default:
+		return fmt.Errorf("exit with policy %s", options.LeaderLostPolicyExit)
+	}
+}
+
+// releaseLeaseTimeout is the max duration to wait for the lease released
+const releaseLeaseTimeout = 5 * time.Second
+
+// releaseLease gives up the lease before the process exits, make sure
+// other nodes could take over without waiting for the lease expired
+func releaseLease(config *options.RecommendedConfig) {
+	releaser, ok := config.LeaderElectionClient.(options.Releaser)
+	if !ok {
+		return
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), releaseLeaseTimeout)
+	defer cancel()
+	if err := releaser.Release(ctx); err != nil {
+		klog.Errorf("release leader election lease: %s", err)
 	}
 }
@@ -6,35 +6,58 @@ import (
 	"testing"
 	"time"
 
-	"github.com/agiledragon/gomonkey/v2"
 	. "github.com/onsi/gomega"
+	"go.uber.org/atomic"
 	"k8s.io/apimachinery/pkg/util/rand"
-	"k8s.io/klog/v2"
 
 	"github.com/everoute/runtime/pkg/options"
 	"github.com/everoute/runtime/pkg/server"
 	. "github.com/everoute/runtime/pkg/util/testing"
 )
 
+type fakeServer struct {
+	stopped chan struct{}
+	err     error
+	// onStop is called when the stopCh closed
+	onStop func()
+}
+
+func newFakeServer(err error) *fakeServer {
+	return &fakeServer{stopped: make(chan struct{}), err: err}
+}
+
+func (s *fakeServer) Run(stopCh <-chan struct{}) error {
+	defer close(s.stopped)
+	if s.err != nil {
+		return s.err
+	}
+	<-stopCh
+	if s.onStop != nil {
+		s.onStop()
+	}
+	return nil
+}
+
 func TestGracefulShutdown(t *testing.T) {
 	RegisterTestingT(t)
 
 	ctx := context.Background()
-	messageCh := make(chan string, 10)
-	patches := gomonkey.ApplyFunc(klog.Fatalf, func(format string, args ...interface{}) {
-		klog.Infof(format, args...)
-		messageCh <- fmt.Sprintf(format, args...)
-	})
-	defer patches.Reset()
+	gracefulShutdown := func(ctx context.Context, config *options.RecommendedConfig, s server.Server) <-chan error {
+		errCh := make(chan error, 1)
+		go func() { errCh <- server.GracefulShutdown(ctx, config, s) }()
+		return errCh
+	}
 
 	t.Run("should shutdown when another node has been becomes leader", func(t *testing.T) {
 		electionClient := NewFakeLeaderElectionClient(rand.String(20))
 		electionClient.SetLeader(rand.String(20))
-		go server.GracefulShutdown(ctx, &options.RecommendedConfig{LeaderElectionClient: electionClient})
+		s := newFakeServer(nil)
 
 		select {
-		case msg := <-messageCh:
-			Expect(msg).Should(ContainSubstring("becomes leader"))
+		case err := <-gracefulShutdown(ctx, &options.RecommendedConfig{LeaderElectionClient: electionClient}, s):
+			Expect(err).Should(MatchError(ContainSubstring("becomes leader")))
+			Expect(server.ExitCode(err)).Should(Equal(server.ExitCodeLeaderChanged))
+			Expect(s.stopped).Should(BeClosed())
 		case <-time.After(time.Second):
 			t.Fatalf("unexpect timeout wait graceful shutdown")
 		}
@@ -43,27 +66,142 @@ func TestGracefulShutdown(t *testing.T) {
 	t.Run("should shutdown when another node becomes leader", func(t *testing.T) {
 		electionClient := NewFakeLeaderElectionClient(rand.String(20))
 		go func() { time.Sleep(200 * time.Millisecond); electionClient.SetLeader(rand.String(20)) }()
-		go server.GracefulShutdown(ctx, &options.RecommendedConfig{LeaderElectionClient: electionClient})
+		s := newFakeServer(nil)
+
+		select {
+		case err := <-gracefulShutdown(ctx, &options.RecommendedConfig{LeaderElectionClient: electionClient}, s):
+			Expect(err).Should(MatchError(ContainSubstring("becomes leader")))
+			Expect(server.ExitCode(err)).Should(Equal(server.ExitCodeLeaderChanged))
+			Expect(s.stopped).Should(BeClosed())
+		case <-time.After(time.Second):
+			t.Fatalf("unexpect timeout wait graceful shutdown")
+		}
+	})
+
+	t.Run("should shutdown and release lease when context done", func(t *testing.T) {
+		ctx, cancel := context.WithTimeout(ctx, 200*time.Millisecond)
+		defer cancel()
+		electionClient := NewFakeLeaderElectionClient(rand.String(20))
+		electionClient.SetLeader(electionClient.Identity())
+		s := newFakeServer(nil)
 
 		select {
-		case msg := <-messageCh:
-			Expect(msg).Should(ContainSubstring("becomes leader"))
+		case err := <-gracefulShutdown(ctx, &options.RecommendedConfig{LeaderElectionClient: electionClient}, s):
+			Expect(err).ShouldNot(HaveOccurred())
+			Expect(s.stopped).Should(BeClosed())
+			Expect(electionClient.IsLeader()).Should(BeFalse())
 		case <-time.After(time.Second):
 			t.Fatalf("unexpect timeout wait graceful shutdown")
 		}
 	})
 
-	t.Run("should shutdown when context done", func(t *testing.T) {
+	t.Run("should release lease before server stopped", func(t *testing.T) {
 		ctx, cancel := context.WithTimeout(ctx, 200*time.Millisecond)
 		defer cancel()
 		electionClient := NewFakeLeaderElectionClient(rand.String(20))
-		go server.GracefulShutdown(ctx, &options.RecommendedConfig{LeaderElectionClient: electionClient})
+		electionClient.SetLeader(electionClient.Identity())
+		var leadingOnStop atomic.Bool
+		s := newFakeServer(nil)
+		s.onStop = func() { leadingOnStop.Store(electionClient.IsLeader()) }
+
+		select {
+		case err := <-gracefulShutdown(ctx, &options.RecommendedConfig{LeaderElectionClient: electionClient}, s):
+			Expect(server.ExitCode(err)).Should(Equal(server.ExitCodeContextDone))
+			Expect(s.stopped).Should(BeClosed())
+			Expect(leadingOnStop.Load()).Should(BeFalse())
+		case <-time.After(time.Second):
+			t.Fatalf("unexpect timeout wait graceful shutdown")
+		}
+	})
+
+	t.Run("should shutdown when server exited", func(t *testing.T) {
+		electionClient := NewFakeLeaderElectionClient(rand.String(20))
+		s := newFakeServer(fmt.Errorf("unexpected error"))
 
 		select {
-		case msg := <-messageCh:
-			Expect(msg).Should(ContainSubstring("stopped when context done"))
+		case err := <-gracefulShutdown(ctx, &options.RecommendedConfig{LeaderElectionClient: electionClient}, s):
+			Expect(err).Should(MatchError(ContainSubstring("unexpected error")))
+			Expect(server.ExitCode(err)).Should(Equal(server.ExitCodeServerError))
 		case <-time.After(time.Second):
 			t.Fatalf("unexpect timeout wait graceful shutdown")
 		}
 	})
 }
+
+func TestExitCode(t *testing.T) {
+	RegisterTestingT(t)
+
+	Expect(server.ExitCode(nil)).Should(Equal(server.ExitCodeContextDone))
+	Expect(server.ExitCode(fmt.Errorf("unknown"))).Should(Equal(server.ExitCodeServerError))
+	Expect(server.ExitCode(fmt.Errorf("wrap: %w", &server.ShutdownReason{ExitCode: 10}))).Should(Equal(10))
+}
+
+// releaseCounter counts the lease released
+type releaseCounter struct {
+	*FakeLeaderElectionClient
+	released atomic.Int32
+}
+
+func (c *releaseCounter) Release(ctx context.Context) error {
+	c.released.Inc()
+	return c.FakeLeaderElectionClient.Release(ctx)
+}
+
+func TestGracefulShutdownLeaderLostPolicy(t *testing.T) {
+	RegisterTestingT(t)
+
+	// loseLeading makes the current node leading then another node becomes leader
+	loseLeading := func(electionClient *FakeLeaderElectionClient, leader string) {
+		electionClient.SetLeader(electionClient.Identity())
+		time.Sleep(100 * time.Millisecond)
+		electionClient.SetLeader(leader)
+	}
+
+	for _, policy := range []options.LeaderLostPolicy{options.LeaderLostPolicyDemote, options.LeaderLostPolicyRestartControllers} {
+		t.Run(fmt.Sprintf("should keep serving with %s policy", policy), func(t *testing.T) {
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+			electionClient := &releaseCounter{FakeLeaderElectionClient: NewFakeLeaderElectionClient(rand.String(20))}
+			s := newFakeServer(nil)
+
+			errCh := make(chan error, 1)
+			go func() {
+				config := &options.RecommendedConfig{LeaderElectionClient: electionClient, LeaderLostPolicy: policy}
+				errCh <- server.GracefulShutdown(ctx, config, s)
+			}()
+
+			loseLeading(electionClient.FakeLeaderElectionClient, rand.String(20))
+			Consistently(errCh, 300*time.Millisecond).ShouldNot(Receive())
+			Expect(s.stopped).ShouldNot(BeClosed())
+			if policy == options.LeaderLostPolicyDemote {
+				Expect(electionClient.released.Load()).Should(Equal(int32(1)))
+			} else {
+				Expect(electionClient.released.Load()).Should(BeZero())
+			}
+
+			cancel()
+			Eventually(errCh).Should(Receive(BeNil()))
+		})
+
+		t.Run(fmt.Sprintf("should not handle leader lost on follower never led with %s policy", policy), func(t *testing.T) {
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+			electionClient := &releaseCounter{FakeLeaderElectionClient: NewFakeLeaderElectionClient(rand.String(20))}
+			electionClient.SetLeader(rand.String(20))
+			s := newFakeServer(nil)
+
+			errCh := make(chan error, 1)
+			go func() {
+				config := &options.RecommendedConfig{LeaderElectionClient: electionClient, LeaderLostPolicy: policy}
+				errCh <- server.GracefulShutdown(ctx, config, s)
+			}()
+
+			Consistently(func() int32 {
+				electionClient.SetLeader(rand.String(20))
+				return electionClient.released.Load()
+			}, 300*time.Millisecond).Should(BeZero())
+			Expect(errCh).ShouldNot(Receive())
+			Expect(s.stopped).ShouldNot(BeClosed())
+		})
+	}
+}
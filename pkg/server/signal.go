@@ -0,0 +1,49 @@
+package server
+
+import (
+	"context"
+	"os"
+	"os/signal"
+	"syscall"
+
+	"k8s.io/klog/v2"
+
+	"github.com/everoute/runtime/pkg/options"
+)
+
+var shutdownSignals = []os.Signal{os.Interrupt, syscall.SIGTERM}
+
+// SetupSignalContext returns a context which canceled when SIGTERM or SIGINT received.
+// On the first signal, the lease is released immediately, so that another node could
+// take over the leadership while the apiserver drains. The process exits directly on
+// the second signal.
+func SetupSignalContext(parent context.Context, config *options.RecommendedConfig) context.Context {
+	ctx, cancel := context.WithCancel(parent)
+	signalCh := make(chan os.Signal, 2)
+	signal.Notify(signalCh, shutdownSignals...)
+
+	go func() {
+		select {
+		case sig := <-signalCh:
+			klog.Infof("received signal %s, shutting down", sig)
+			cancel()
+		case <-ctx.Done():
+			signal.Stop(signalCh)
+			return
+		}
+		go releaseLease(config)
+
+		sig := <-signalCh
+		klog.Errorf("received signal %s again, force exit", sig)
+		klog.FlushAndExit(klog.ExitFlushTimeout, ExitCodeServerError)
+	}()
+
+	return ctx
+}
+
+// Run runs the apiserver until the context done, SIGTERM or SIGINT received, or
+// another node becomes leader, and graceful shutdown it. The returned error could
+// be used as the exit code by Exit.
+func Run(ctx context.Context, config *options.RecommendedConfig, server Server) error {
+	return GracefulShutdown(SetupSignalContext(ctx, config), config, server)
+}
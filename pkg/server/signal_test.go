@@ -0,0 +1,57 @@
+package server_test
+
+import (
+	"context"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/agiledragon/gomonkey/v2"
+	. "github.com/onsi/gomega"
+	"go.uber.org/atomic"
+	"k8s.io/apimachinery/pkg/util/rand"
+	"k8s.io/klog/v2"
+
+	"github.com/everoute/runtime/pkg/options"
+	"github.com/everoute/runtime/pkg/server"
+	. "github.com/everoute/runtime/pkg/util/testing"
+)
+
+func TestSetupSignalContext(t *testing.T) {
+	RegisterTestingT(t)
+
+	var exitCode atomic.Int32
+	exitCode.Store(-1)
+	patch := gomonkey.ApplyFunc(klog.FlushAndExit, func(_ time.Duration, code int) { exitCode.Store(int32(code)) })
+	defer patch.Reset()
+
+	electionClient := NewFakeLeaderElectionClient(rand.String(20))
+	electionClient.SetLeader(electionClient.Identity())
+	ctx := server.SetupSignalContext(context.Background(), &options.RecommendedConfig{LeaderElectionClient: electionClient})
+
+	t.Run("should cancel context and release lease on the first signal", func(t *testing.T) {
+		Expect(syscall.Kill(syscall.Getpid(), syscall.SIGTERM)).ShouldNot(HaveOccurred())
+		Eventually(ctx.Done()).Should(BeClosed())
+		Eventually(electionClient.IsLeader).Should(BeFalse())
+		Consistently(exitCode.Load, 100*time.Millisecond).Should(Equal(int32(-1)))
+	})
+
+	t.Run("should force exit on the second signal", func(t *testing.T) {
+		Expect(syscall.Kill(syscall.Getpid(), syscall.SIGINT)).ShouldNot(HaveOccurred())
+		Eventually(exitCode.Load).Should(Equal(int32(server.ExitCodeServerError)))
+	})
+}
+
+func TestRun(t *testing.T) {
+	RegisterTestingT(t)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	s := newFakeServer(nil)
+	errCh := make(chan error, 1)
+	go func() { errCh <- server.Run(ctx, &options.RecommendedConfig{}, s) }()
+
+	Consistently(errCh, 100*time.Millisecond).ShouldNot(Receive())
+	cancel()
+	Eventually(errCh).Should(Receive(WithTransform(server.ExitCode, Equal(server.ExitCodeContextDone))))
+	Expect(s.stopped).Should(BeClosed())
+}
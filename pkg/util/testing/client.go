@@ -47,6 +47,8 @@ func NewTestingClient(t *testing.T, s *cobra.Command, args ...string) *rest.Conf
 		"--serve-tls-crt-path=" + filepath.Join(tmpPath, "tls.crt"),
 		"--serve-tls-key-path=" + filepath.Join(tmpPath, "tls.key"),
 		"--etcd-servers=" + strings.Join(server.V3Client.Endpoints(), ","),
+		// the built-in plugins read from the core cluster, which is unavailable in testing
+		"--disable-admission-plugins=NamespaceLifecycle,MutatingAdmissionWebhook,ValidatingAdmissionPolicy,ValidatingAdmissionWebhook",
 	}
 	if len(args) != 0 {
 		os.Args = args
@@ -1,14 +1,29 @@
 package testing
 
 import (
+	"context"
+	"net"
 	"sync"
 
+	"github.com/agiledragon/gomonkey/v2"
+	. "github.com/onsi/gomega"
+	"github.com/spf13/pflag"
 	"go.uber.org/atomic"
+	"k8s.io/apimachinery/pkg/util/rand"
+	genericapiserver "k8s.io/apiserver/pkg/server"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/kubernetes/scheme"
+
+	"github.com/everoute/runtime/pkg/options"
 )
 
+// FakeLeaderElectionClient sets the leader by SetLeader, it works the same as the election client
+// of the options: the leading state update notified when the leader changed or released, and the
+// node no longer participates in election after released
 type FakeLeaderElectionClient struct {
 	leaderName             atomic.String
 	name                   string
+	released               atomic.Bool
 	leadingStateUpdateCond *sync.Cond
 }
 
@@ -24,11 +39,23 @@ func (c *FakeLeaderElectionClient) GetLeader() string { return c.leaderName.Load
 func (c *FakeLeaderElectionClient) IsLeader() bool    { return c.leaderName.Load() == c.name }
 func (c *FakeLeaderElectionClient) Identity() string  { return c.name }
 
+// SetLeader sets the observed leader, the current node never becomes leader after released
 func (c *FakeLeaderElectionClient) SetLeader(name string) {
+	if name == c.name && c.released.Load() {
+		return
+	}
 	c.leaderName.Store(name)
 	c.leadingStateUpdateCond.Broadcast()
 }
 
+// Release stops the election, the lease released if leading, and notifies the stopped leading
+func (c *FakeLeaderElectionClient) Release(context.Context) error {
+	c.released.Store(true)
+	c.leaderName.CompareAndSwap(c.name, "")
+	c.leadingStateUpdateCond.Broadcast()
+	return nil
+}
+
 func (c *FakeLeaderElectionClient) UntilLeadingStateUpdate(stopCh <-chan struct{}) bool {
 	go func() { // make sure never hang when stop
 		<-stopCh
@@ -46,3 +73,30 @@ func (c *FakeLeaderElectionClient) UntilLeadingStateUpdate(stopCh <-chan struct{
 		return true
 	}
 }
+
+// NewElectionClient returns the election client created by the election options with the
+// lease in the clientset, the identity has the address prefix. The election runs until the
+// stopCh closed, the args are the election flags, e.g. --election-retry-period=100ms.
+func NewElectionClient(clientset kubernetes.Interface, address net.IP, stopCh <-chan struct{}, args ...string) options.LeaderElectionClient {
+	opts := options.NewElectionOptions()
+	fs := pflag.NewFlagSet("", pflag.ContinueOnError)
+	opts.AddFlags(fs)
+	Expect(fs.Parse(append([]string{"--election-enabled", "--election-name=" + rand.String(20)}, args...))).ShouldNot(HaveOccurred())
+	Expect(opts.Validate()).Should(BeEmpty())
+
+	config := options.NewRecommendedConfig(scheme.Codecs)
+	config.Clientset = clientset
+	config.PublicAddress = address
+
+	var hook genericapiserver.PostStartHookFunc
+	patch := gomonkey.ApplyMethodFunc(&config.Config, "AddPostStartHook", func(_ string, h genericapiserver.PostStartHookFunc) error {
+		hook = h
+		return nil
+	})
+	defer patch.Reset()
+
+	Expect(opts.ApplyTo(config)).ShouldNot(HaveOccurred())
+	Expect(hook).ShouldNot(BeNil())
+	Expect(hook(genericapiserver.PostStartHookContext{StopCh: stopCh})).ShouldNot(HaveOccurred())
+	return config.LeaderElectionClient
+}
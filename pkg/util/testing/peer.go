@@ -0,0 +1,67 @@
+package testing
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/samber/lo"
+	"k8s.io/apimachinery/pkg/util/rand"
+	genericapiserver "k8s.io/apiserver/pkg/server"
+	"k8s.io/apiserver/pkg/server/dynamiccertificates"
+)
+
+// PeerServer is a tls server serves as another node, it listens on 127.0.0.2
+// with the same port as the local listener which listens on 127.0.0.1
+type PeerServer struct {
+	*httptest.Server
+	CA            *RootCertificate
+	LocalListener net.Listener
+	LocalCert     *TLSCertificate
+}
+
+// NewPeerServer starts a peer server which requires client certificate
+func NewPeerServer(t *testing.T, handler http.Handler) *PeerServer {
+	ca := lo.Must(GenerateRootCertificate(2048, time.Hour))
+	peerCert := lo.Must(ca.GenerateServerCerts("127.0.0.2", "", "", time.Now().Add(time.Hour)))
+	localCert := lo.Must(ca.GenerateServerCerts("127.0.0.1", "", "", time.Now().Add(time.Hour)))
+
+	peerListener := lo.Must(net.Listen("tcp", "127.0.0.2:0"))
+	port := peerListener.Addr().(*net.TCPAddr).Port
+	localListener := lo.Must(net.Listen("tcp", "127.0.0.1:"+strconv.Itoa(port)))
+	t.Cleanup(func() { localListener.Close() })
+
+	clientCAs := x509.NewCertPool()
+	clientCAs.AppendCertsFromPEM(ca.CAContent)
+	server := httptest.NewUnstartedServer(handler)
+	server.Listener.Close()
+	server.Listener = peerListener
+	server.TLS = &tls.Config{
+		Certificates: []tls.Certificate{lo.Must(tls.X509KeyPair(peerCert.CrtContent, peerCert.KeyContent))},
+		ClientAuth:   tls.RequireAndVerifyClientCert,
+		ClientCAs:    clientCAs,
+	}
+	server.StartTLS()
+	t.Cleanup(server.Close)
+
+	return &PeerServer{Server: server, CA: ca, LocalListener: localListener, LocalCert: localCert}
+}
+
+// Identity returns a random identity of the peer node
+func (s *PeerServer) Identity() string {
+	return "127.0.0.2_" + rand.String(10)
+}
+
+// LocalSecureServing returns the local secure serving info with the local listener and certificates
+func (s *PeerServer) LocalSecureServing() *genericapiserver.SecureServingInfo {
+	return &genericapiserver.SecureServingInfo{
+		Listener: s.LocalListener,
+		Cert:     lo.Must(dynamiccertificates.NewStaticCertKeyContent("serving-cert", s.LocalCert.CrtContent, s.LocalCert.KeyContent)),
+		ClientCA: lo.Must(dynamiccertificates.NewStaticCAContent("client-ca", s.CA.CAContent)),
+	}
+}